@@ -59,7 +59,7 @@ func main() {
 		Use:   "redis-lite-cli",
 		Short: "Redis CLI tool",
 		Run: func(cmd *cobra.Command, args []string) {
-			address := fmt.Sprintf("%s:%s", host, port)
+			address := net.JoinHostPort(host, port)
 
 			conn, err := net.Dial("tcp", address)
 			if err != nil {
@@ -132,7 +132,7 @@ func WaitForInput(host, port string, conn net.Conn) {
 
 			var cmdPayload resp.Payload
 			for _, part := range parts {
-				p := resp.Payload{DataType: string(resp.BULKSTRING), Bulk: part}
+				p := resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(part)}
 				cmdPayload.Array = append(cmdPayload.Array, p)
 			}
 
@@ -149,23 +149,25 @@ func WaitForInput(host, port string, conn net.Conn) {
 			cmd, err := respReader.Read()
 			if err != nil {
 				fmt.Println("(error) Err reading response:", err)
-			} else if cmd.DataType == "" {
-				fmt.Println("(nil)")
+			} else {
+				// Print the response
+				printRedisServerAnswer(cmd)
 			}
-
-			// Print the response
-			printRedisServerAnswer(cmd)
 			fmt.Print(host, ":", port, "> ")
 		}
 	}
 }
 
 func printRedisServerAnswer(cmd resp.Payload) {
+	if cmd.Null {
+		fmt.Println("(nil)")
+		return
+	}
 	switch cmd.DataType {
 	case string(resp.ERROR):
-		fmt.Println("Error:", cmd.Str)
+		fmt.Println("(error)", cmd.Str)
 	case string(resp.BULKSTRING):
-		fmt.Println(cmd.Bulk)
+		fmt.Println(string(cmd.Bulk))
 	case string(resp.INTEGER):
 		fmt.Println("(integer) ", cmd.Num)
 	case string(resp.STRING):
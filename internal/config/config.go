@@ -0,0 +1,238 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server settings, read from a file using the redis.conf format: one
+// "name value" directive per line, lines starting with # are comments.
+// Settings can also be read and changed at runtime with CONFIG GET and
+// CONFIG SET.
+
+type parameter struct {
+	defaultValue string
+	validate     func(string) error
+}
+
+var parameters = map[string]parameter{
+	"appendfilename":              {"database.aof", fileName},
+	"appendfsync":                 {"everysec", oneOf("always", "everysec", "no")},
+	"aof-load-truncated":          {"yes", oneOf("yes", "no")},
+	"appendonly":                  {"yes", oneOf("yes", "no")},
+	"auto-aof-rewrite-min-size":   {"64mb", memoryMin(0)},
+	"auto-aof-rewrite-percentage": {"100", intRange(0, math.MaxInt32)},
+	"dbfilename":                  {"dump.snap", fileName},
+	"dir":                         {"data/redis-lite", notEmpty},
+	"encryption-key-file":         {"", nil},
+	"hz":                          {"10", intRange(1, 500)},
+	"proto-max-bulk-len":          {"512mb", memoryMin(1024 * 1024)},
+	"requirepass":                 {"", nil},
+	"save":                        {"3600 1 300 100 60 10000", saveParams},
+}
+
+// Settings only read at startup, CONFIG SET refuses to change them
+var immutable = map[string]bool{
+	"appendfilename":      true,
+	"dir":                 true,
+	"encryption-key-file": true,
+}
+
+func intRange(min, max int) func(string) error {
+	return func(s string) error {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return fmt.Errorf("argument must be an integer between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+// saveParams accepts "<seconds> <changes>" pairs, none disabling snapshots
+func saveParams(s string) error {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return errors.New("argument must be pairs of seconds and changes")
+	}
+	for _, field := range fields {
+		if v, err := strconv.Atoi(field); err != nil || v < 0 {
+			return errors.New("argument must be pairs of seconds and changes")
+		}
+	}
+	return nil
+}
+
+func notEmpty(s string) error {
+	if s == "" {
+		return errors.New("argument must not be empty")
+	}
+	return nil
+}
+
+// fileName accepts a file name, not a path
+func fileName(s string) error {
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return errors.New("argument must be a file name, not a path")
+	}
+	return nil
+}
+
+// oneOf accepts one of the values, whatever the case
+func oneOf(values ...string) func(string) error {
+	return func(s string) error {
+		for _, v := range values {
+			if strings.EqualFold(s, v) {
+				return nil
+			}
+		}
+		return errors.New("argument must be one of " + strings.Join(values, ", "))
+	}
+}
+
+// parseMemory parses a size with an optional unit, as 1024, 64kb or 512mb
+func parseMemory(s string) (int64, error) {
+	s = strings.ToLower(s)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		value  int64
+	}{{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSuffix(s, unit.suffix), unit.value
+			break
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 || v > math.MaxInt64/multiplier {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return v * multiplier, nil
+}
+
+func memoryMin(min int64) func(string) error {
+	return func(s string) error {
+		v, err := parseMemory(s)
+		if err != nil {
+			return err
+		}
+		if v < min {
+			return fmt.Errorf("argument must be at least %d bytes", min)
+		}
+		return nil
+	}
+}
+
+type Config struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// New returns a configuration holding the default values
+func New() *Config {
+	c := &Config{values: map[string]string{}}
+	for name, p := range parameters {
+		c.values[name] = p.defaultValue
+	}
+	return c
+}
+
+// Load reads the configuration file, missing settings keep their default value
+func Load(filename string) (*Config, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := New()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(text, " ")
+		value = strings.TrimSpace(value)
+		// save "" and the like
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		if err := c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+	}
+	return c, scanner.Err()
+}
+
+func (c *Config) Get(name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.values[strings.ToLower(name)]
+	return value, ok
+}
+
+// Match returns the names of the settings matching the glob-style pattern
+func (c *Config) Match(pattern string) []string {
+	var names []string
+	for name := range parameters {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) Set(name, value string) error {
+	name = strings.ToLower(name)
+	p, ok := parameters[name]
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+	if p.validate != nil {
+		if err := p.validate(value); err != nil {
+			return fmt.Errorf("invalid argument '%s' for '%s': %w", value, name, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] = value
+	return nil
+}
+
+// Update changes a setting at runtime, as CONFIG SET does, refusing the
+// settings only read at startup
+func (c *Config) Update(name, value string) error {
+	if immutable[strings.ToLower(name)] {
+		return fmt.Errorf("can't set immutable config '%s'", strings.ToLower(name))
+	}
+	return c.Set(name, value)
+}
+
+// Int returns the value of a setting validated as an integer
+func (c *Config) Int(name string) int {
+	value, _ := c.Get(name)
+	v, _ := strconv.Atoi(value)
+	return v
+}
+
+// Bytes returns the value of a setting validated as a memory size
+func (c *Config) Bytes(name string) int64 {
+	value, _ := c.Get(name)
+	v, _ := parseMemory(value)
+	return v
+}
+
+// Enum returns the value of a setting validated by oneOf, in lower case
+func (c *Config) Enum(name string) string {
+	value, _ := c.Get(name)
+	return strings.ToLower(value)
+}
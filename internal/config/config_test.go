@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaults(t *testing.T) {
+	c := New()
+	require.Equal(t, 10, c.Int("hz"))
+	_, ok := c.Get("unknown")
+	require.False(t, ok)
+}
+
+func TestSet(t *testing.T) {
+	c := New()
+	require.NoError(t, c.Set("HZ", "100"))
+	require.Equal(t, 100, c.Int("hz"))
+	require.Error(t, c.Set("hz", "0"))
+	require.Error(t, c.Set("hz", "abc"))
+	require.Error(t, c.Set("unknown", "1"))
+	require.Equal(t, 100, c.Int("hz"))
+}
+
+func TestEnum(t *testing.T) {
+	c := New()
+	require.Equal(t, "everysec", c.Enum("appendfsync"))
+	require.NoError(t, c.Set("appendfsync", "Always"))
+	require.Equal(t, "always", c.Enum("appendfsync"))
+	require.Error(t, c.Set("appendfsync", "sometimes"))
+}
+
+func TestUpdate(t *testing.T) {
+	c := New()
+	require.NoError(t, c.Update("dbfilename", "other.snap"))
+	require.Error(t, c.Update("dbfilename", "../other.snap"))
+	require.ErrorContains(t, c.Update("DIR", "/tmp"), "immutable")
+	require.ErrorContains(t, c.Update("appendfilename", "other.aof"), "immutable")
+	// Read at startup
+	require.NoError(t, c.Set("dir", "/tmp"))
+}
+
+func TestMatch(t *testing.T) {
+	c := New()
+	require.Equal(t, []string{"aof-load-truncated", "appendfilename", "appendfsync", "appendonly", "auto-aof-rewrite-min-size", "auto-aof-rewrite-percentage", "dbfilename", "dir", "encryption-key-file", "hz", "proto-max-bulk-len", "requirepass", "save"}, c.Match("*"))
+	require.Equal(t, []string{"hz"}, c.Match("H?"))
+	require.Empty(t, c.Match("foo*"))
+}
+
+func TestLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("# comment\n\nhz 50\n"), 0644))
+	c, err := Load(filename)
+	require.NoError(t, err)
+	require.Equal(t, 50, c.Int("hz"))
+
+	require.NoError(t, os.WriteFile(filename, []byte("save \"\"\n"), 0644))
+	c, err = Load(filename)
+	require.NoError(t, err)
+	value, _ := c.Get("save")
+	require.Empty(t, value)
+
+	require.NoError(t, os.WriteFile(filename, []byte("hz 50\nfoo bar\n"), 0644))
+	_, err = Load(filename)
+	require.ErrorContains(t, err, "redis.conf:2")
+}
+
+func TestMemory(t *testing.T) {
+	c := New()
+	require.Equal(t, int64(512*1024*1024), c.Bytes("proto-max-bulk-len"))
+	require.NoError(t, c.Set("proto-max-bulk-len", "2MB"))
+	require.Equal(t, int64(2*1024*1024), c.Bytes("proto-max-bulk-len"))
+	require.NoError(t, c.Set("proto-max-bulk-len", "5000000"))
+	require.Equal(t, int64(5000000), c.Bytes("proto-max-bulk-len"))
+	require.NoError(t, c.Set("proto-max-bulk-len", "2m"))
+	require.Equal(t, int64(2000000), c.Bytes("proto-max-bulk-len"))
+	require.Error(t, c.Set("proto-max-bulk-len", "1kb"))
+	require.Error(t, c.Set("proto-max-bulk-len", "-1"))
+	require.Error(t, c.Set("proto-max-bulk-len", "mb"))
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Offline check of the AOF, the redis-check-aof of redis lite. The
+// incremental files and a base file of older versions are read command by
+// command without executing them, a snapshot or RDB base file is decoded.
+// Encrypted files need the keys, see LoadEncryptionKeys.
+
+// CheckAof validates an AOF file, or the files listed by a manifest, and
+// with fix truncates the AOF files found invalid at the end of their last
+// valid command. It returns a report of the files analyzed.
+func CheckAof(name string, fix bool) (string, error) {
+	names := []string{name}
+	if strings.HasSuffix(name, ".manifest") {
+		m, err := readManifest(name)
+		if err != nil {
+			return "", err
+		}
+		names = names[:0]
+		if m.base != nil {
+			names = append(names, filepath.Join(filepath.Dir(name), m.base.name))
+		}
+		for _, incr := range m.incrs {
+			names = append(names, filepath.Join(filepath.Dir(name), incr.name))
+		}
+	}
+
+	var report strings.Builder
+	invalid := 0
+	for _, name := range names {
+		if !checkAofFile(&report, name, fix) {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return report.String(), fmt.Errorf("%d invalid AOF file(s)", invalid)
+	}
+	return report.String(), nil
+}
+
+// checkAofFile writes the result of the check of an AOF file to report,
+// telling if the file is valid, or was fixed
+func checkAofFile(report *strings.Builder, name string, fix bool) bool {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(report, err)
+		return false
+	}
+	// Base files are named .snap, and never truncated
+	if strings.HasSuffix(name, ".snap") || isDump(b) {
+		keyspaceLock.Lock()
+		defer keyspaceLock.Unlock()
+		b, err := unsealFile(b)
+		if err == nil {
+			decode := decodeSnapshot
+			if isRdb(b) {
+				decode = decodeRdb
+			}
+			err = decode(b)
+		}
+		if err != nil {
+			fmt.Fprintf(report, "Base file %s is not valid, it can't be fixed: %v\n", name, err)
+			return false
+		}
+		fmt.Fprintf(report, "Base file %s is valid\n", name)
+		return true
+	}
+
+	valid, err := scanAofFile(b, func(string, []resp.Payload) {})
+	fmt.Fprintf(report, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n", name, len(b), valid, int64(len(b))-valid)
+	if err == nil {
+		fmt.Fprintf(report, "AOF %s is valid\n", name)
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = errors.New("partial command at the end of the file")
+	}
+	fmt.Fprintf(report, "AOF %s is not valid at offset %d: %v\n", name, valid, err)
+	if !fix {
+		fmt.Fprintln(report, "Use the --fix option to truncate it there")
+		return false
+	}
+	if err := os.Truncate(name, valid); err != nil {
+		fmt.Fprintf(report, "Failed to truncate AOF %s: %v\n", name, err)
+		return false
+	}
+	fmt.Fprintf(report, "Successfully truncated AOF %s, %d bytes dropped\n", name, int64(len(b))-valid)
+	return true
+}
+
+// isDump tells snapshots and RDB files apart from files of commands,
+// encrypted or not. Encrypted files are told apart by their first record.
+func isDump(b []byte) bool {
+	if isSealed(b) {
+		unsealRecords(b, func(record []byte) error {
+			b = record
+			return io.EOF
+		})
+	}
+	return isRdb(b) || bytes.HasPrefix(b, []byte(snapshotMagic))
+}
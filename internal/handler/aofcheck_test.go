@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// corruptAof writes an AOF with a manifest made of a base snapshot and an
+// incremental file holding the commands, followed by tail
+func corruptAof(t *testing.T, tail string) (dir, incr string) {
+	dir = t.TempDir()
+	keyspace = map[string]*redisObject{}
+	setKey("base", &redisObject{kind: stringType, value: "v"})
+	writeSnapshot(encodeSnapshot(), filepath.Join(dir, baseName(1)))
+	m := &aofManifest{base: &aofFile{name: baseName(1), seq: 1, kind: aofBaseType}}
+	m.incrs = append(m.incrs, aofFile{name: incrName(1), seq: 1, kind: aofIncrType})
+	m.save(dir)
+	incr = filepath.Join(dir, incrName(1))
+	content := string(request("SET", "a", "1").Write()) + tail
+	os.WriteFile(incr, []byte(content), 0666)
+	return dir, incr
+}
+
+func TestAofCorruption(t *testing.T) {
+	// Refused at startup unless aof-load-truncated is yes
+	dir, _ := corruptAof(t, "*3\r\n$3\r\nSET\r\n$1\r\nb")
+	serverConfig.Set("aof-load-truncated", "no")
+	_, err := openAof(dir)
+	serverConfig.Set("aof-load-truncated", "yes")
+	if err == nil || !strings.Contains(err.Error(), "offset 27") {
+		t.Errorf("Expected the partial command at offset 27 to be refused, got %v", err)
+	}
+
+	// Never loaded past a corruption in the middle of a file
+	for _, tail := range []string{
+		"*2\r\n$3\r\nGET\r\nx\r\n" + string(request("SET", "b", "1").Write()),
+		string(request("NOTACOMMAND", "b").Write()),
+		":1\r\n",
+	} {
+		dir, _ := corruptAof(t, tail)
+		if _, err := openAof(dir); err == nil || !strings.Contains(err.Error(), "corrupted at offset 27") {
+			t.Errorf("Expected %q to be refused at offset 27, got %v", tail, err)
+		}
+	}
+}
+
+func TestCheckAof(t *testing.T) {
+	dir, incr := corruptAof(t, "")
+	manifest := manifestPath(dir)
+	if report, err := CheckAof(manifest, false); err != nil || !strings.Contains(report, "AOF "+incr+" is valid") {
+		t.Errorf("Expected a valid AOF, got %v\n%s", err, report)
+	}
+
+	dir, incr = corruptAof(t, "*2\r\n$3\r\nGET")
+	manifest = manifestPath(dir)
+	report, err := CheckAof(manifest, false)
+	if err == nil || !strings.Contains(report, "not valid at offset 27") {
+		t.Errorf("Expected an invalid AOF at offset 27, got %v\n%s", err, report)
+	}
+	if report, err = CheckAof(incr, true); err != nil || !strings.Contains(report, "Successfully truncated") {
+		t.Errorf("Expected the AOF to be fixed, got %v\n%s", err, report)
+	}
+	if info, _ := os.Stat(incr); info.Size() != 27 {
+		t.Errorf("Expected the AOF truncated to 27 bytes, got %d", info.Size())
+	}
+	if _, err := CheckAof(manifest, false); err != nil {
+		t.Errorf("Expected the fixed AOF to be valid, got %v", err)
+	}
+	aof := openTestAof(t, dir)
+	aof.Close()
+	if got := dataset(); len(got) != 2 {
+		t.Errorf("Expected the base and the valid command to be loaded, got %v", got)
+	}
+
+	base := filepath.Join(dir, baseName(1))
+	os.WriteFile(base, []byte("REDISLITE0001garbage"), 0666)
+	if report, err := CheckAof(manifest, true); err == nil || !strings.Contains(report, "can't be fixed") {
+		t.Errorf("Expected a corrupted base to be reported, got %v\n%s", err, report)
+	}
+}
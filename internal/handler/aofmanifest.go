@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Multi-part AOF, as in Redis 7. The AOF is made of a base file, a snapshot
+// written by the last rewrite, and of incremental files holding the commands
+// executed since. A rewrite starts a new incremental file right away, so the
+// commands executed meanwhile need no buffering, and replaces the base and the
+// previous incremental files once the new base is written.
+// The manifest lists the files in load order, one per line:
+//
+//	file database.aof.2.base.snap seq 2 type b
+//	file database.aof.3.incr.aof seq 3 type i
+//
+// database.aof being the appendfilename setting.
+
+// aofName is the prefix of the names of the AOF files
+func aofName() string {
+	name, _ := serverConfig.Get("appendfilename")
+	return name
+}
+
+// Types of the files of the manifest. Redis also lists the files replaced by
+// a rewrite as history files until they are deleted, they are skipped here.
+const (
+	aofBaseType    = "b"
+	aofHistoryType = "h"
+	aofIncrType    = "i"
+)
+
+type aofFile struct {
+	name string
+	seq  int
+	kind string
+}
+
+type aofManifest struct {
+	base  *aofFile
+	incrs []aofFile
+}
+
+func manifestPath(dir string) string {
+	return filepath.Join(dir, aofName()+".manifest")
+}
+
+func baseName(seq int) string {
+	return fmt.Sprintf("%s.%d.base.snap", aofName(), seq)
+}
+
+func incrName(seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", aofName(), seq)
+}
+
+// loadManifest reads the manifest of dir, a missing manifest gives an empty
+// one
+func loadManifest(dir string) (*aofManifest, error) {
+	return readManifest(manifestPath(dir))
+}
+
+func readManifest(name string) (*aofManifest, error) {
+	m := &aofManifest{}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %s", line, text)
+		}
+		var file aofFile
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				file.name = fields[i+1]
+			case "seq":
+				file.seq, err = strconv.Atoi(fields[i+1])
+			case "type":
+				file.kind = fields[i+1]
+			}
+		}
+		if file.name == "" || err != nil || strings.ContainsAny(file.name, `/\`) {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %s", line, text)
+		}
+		switch file.kind {
+		case aofBaseType:
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: more than one base file", line)
+			}
+			m.base = &file
+		case aofIncrType:
+			m.incrs = append(m.incrs, file)
+		case aofHistoryType:
+		default:
+			return nil, fmt.Errorf("invalid AOF manifest line %d: unknown file type %s", line, file.kind)
+		}
+	}
+	return m, scanner.Err()
+}
+
+func (m *aofManifest) String() string {
+	var b strings.Builder
+	files := m.incrs
+	if m.base != nil {
+		files = append([]aofFile{*m.base}, files...)
+	}
+	for _, f := range files {
+		fmt.Fprintf(&b, "file %s seq %d type %s\n", f.name, f.seq, f.kind)
+	}
+	return b.String()
+}
+
+// lastIncr returns the incremental file commands are appended to
+func (m *aofManifest) lastIncr() *aofFile {
+	if len(m.incrs) == 0 {
+		return nil
+	}
+	return &m.incrs[len(m.incrs)-1]
+}
+
+// nextIncrSeq returns the sequence number of a new incremental file
+func (m *aofManifest) nextIncrSeq() int {
+	if incr := m.lastIncr(); incr != nil {
+		return incr.seq + 1
+	}
+	return 1
+}
+
+// save writes the manifest to a temporary file renamed over the previous one,
+// so it is never seen partially written
+func (m *aofManifest) save(dir string) error {
+	tmp, err := os.CreateTemp(dir, "temp-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.WriteString(m.String()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), manifestPath(dir))
+}
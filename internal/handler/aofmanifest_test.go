@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	content := "file database.aof.2.base.snap seq 2 type b\n" +
+		"file database.aof.1.incr.aof seq 1 type h\n" +
+		"file database.aof.2.incr.aof seq 2 type i\n" +
+		"file database.aof.3.incr.aof seq 3 type i\n"
+	os.WriteFile(manifestPath(dir), []byte(content), 0666)
+
+	m, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.base == nil || m.base.name != "database.aof.2.base.snap" || len(m.incrs) != 2 || m.nextIncrSeq() != 4 {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	// History files are not kept
+	if want := strings.Replace(content, "file database.aof.1.incr.aof seq 1 type h\n", "", 1); m.String() != want {
+		t.Errorf("Expected %q, got %q", want, m.String())
+	}
+
+	for _, invalid := range []string{
+		"file database.aof.1.incr.aof seq 1\n",
+		"file database.aof.1.incr.aof seq x type i\n",
+		"file database.aof.1.incr.aof seq 1 type z\n",
+		"file ../database.aof seq 1 type i\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+	} {
+		os.WriteFile(manifestPath(dir), []byte(invalid), 0666)
+		if _, err := loadManifest(dir); err == nil {
+			t.Errorf("Expected an error loading %q", invalid)
+		}
+	}
+}
+
+func TestAofUpgradeTruncated(t *testing.T) {
+	dir := t.TempDir()
+	keyspace = map[string]*redisObject{}
+	b := append(request("SET", "old", "v").Write(), request("SET", "torn", "v").Write()[:10]...)
+	os.WriteFile(filepath.Join(dir, aofName()), b, 0666)
+
+	setConfig(t, "aof-load-truncated", "no")
+	if _, err := openAof(dir); err == nil || !strings.Contains(err.Error(), "partial command") {
+		t.Fatalf("Expected the partial command to be refused, got %v", err)
+	}
+	setConfig(t, "aof-load-truncated", "yes")
+	openTestAof(t, dir)
+	if got := get(args("old")); string(got.Bulk) != "v" {
+		t.Errorf("Expected the upgraded AOF to be loaded, got %v", got)
+	}
+}
+
+func TestAofMultiPart(t *testing.T) {
+	dir := t.TempDir()
+	keyspace = map[string]*redisObject{}
+
+	// A single AOF file of older versions is loaded as the base
+	os.WriteFile(filepath.Join(dir, aofName()), request("SET", "old", "v").Write(), 0666)
+	aof := openTestAof(t, dir)
+	if got := get(args("old")); string(got.Bulk) != "v" {
+		t.Fatalf("Expected the previous AOF to be loaded, got %v", got)
+	}
+	processRequest(nil, request("SET", "key", "1"), aof)
+	processRequest(nil, request("BGREWRITEAOF"), aof)
+	waitRewrite(t, aof)
+	if _, err := os.Stat(filepath.Join(dir, aofName())); !os.IsNotExist(err) {
+		t.Errorf("Expected the previous AOF to be replaced by the rewrite")
+	}
+
+	processRequest(nil, request("SET", "key", "2"), aof)
+	processRequest(nil, request("BGREWRITEAOF"), aof)
+	waitRewrite(t, aof)
+	processRequest(nil, request("RPUSH", "list", "a"), aof)
+	processRequest(nil, request("BGREWRITEAOF"), aof)
+	waitRewrite(t, aof)
+	inBase := dataset()
+	processRequest(nil, request("RPUSH", "list", "b"), aof)
+	expected := dataset()
+	aof.Close()
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{baseName(4), incrName(4), "database.aof.manifest"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected files %v, got %v", want, names)
+	}
+
+	aof = openTestAof(t, dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after restart, got %v", expected, got)
+	}
+
+	// A command partially written at the end of the last file is dropped
+	aof.Close()
+	name := filepath.Join(dir, incrName(4))
+	f, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0666)
+	info, _ := f.Stat()
+	f.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$2\r\n4"))
+	f.Close()
+	aof = openTestAof(t, dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after dropping the partial command, got %v", expected, got)
+	}
+	if after, _ := os.Stat(name); after.Size() != info.Size() {
+		t.Errorf("Expected the partial command to be truncated, size %d", after.Size())
+	}
+	aof.Close()
+
+	// Missing incremental files are skipped
+	os.Remove(name)
+	openTestAof(t, dir)
+	if got := dataset(); !reflect.DeepEqual(got, inBase) {
+		t.Errorf("Expected %v without the incremental file, got %v", inBase, got)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("Expected the missing incremental file to be created: %v", err)
+	}
+}
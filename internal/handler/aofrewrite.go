@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// AOF rewrite. The AOF only grows as commands are appended, a rewrite
+// replaces it with a snapshot of the current dataset as the new base file.
+// Go can not fork, so the point-in-time view of the dataset is taken by
+// encoding it while the keyspace is locked, and commands executed from then
+// on are appended to a new incremental file. The snapshot is written in the
+// background, then replaces the previous base and incremental files in the
+// manifest.
+
+var errRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+var errAofDisabled = errors.New("Append only file is disabled")
+
+// startRewrite switches to a new incremental file and writes the snapshot of
+// the dataset in the background, keyspaceLock must be held
+func (a *Aof) startRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return errAofDisabled
+	}
+	if a.rewriting {
+		return errRewriteInProgress
+	}
+	// What failed to be written belongs to the current incremental file
+	if a.lastWriteErr != nil {
+		return a.lastWriteErr
+	}
+	// Rotates the encryption keys
+	if err := loadKeys(); err != nil {
+		return err
+	}
+	if err := a.openNewIncr(); err != nil {
+		return err
+	}
+	a.rewriteInBackground(false)
+	return nil
+}
+
+// rewriteInBackground writes the snapshot of the dataset as the new base
+// file in the background, once commands are appended to a new incremental
+// file. When seeding the AOF turned on at runtime, the AOF is turned off
+// again if the rewrite fails. keyspaceLock and a.mu must be held.
+func (a *Aof) rewriteInBackground(seeding bool) {
+	a.rewriting = true
+	b := encodeSnapshot()
+	go func() {
+		err := a.rewrite(b)
+		if err != nil {
+			log.Println("Background AOF rewrite failed:", err)
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.rewriting = false
+		a.lastRewriteErr = err
+		if err != nil && seeding {
+			log.Println("Append only file could not be turned on")
+			a.closeFile()
+			serverConfig.Set("appendonly", "no")
+		}
+	}()
+}
+
+// startAppendOnly turns the AOF on at runtime. Commands are appended to a
+// new incremental file right away, and a rewrite seeds the AOF with the
+// dataset. The manifest is only saved with the new files once the rewrite
+// completes, until then it keeps describing the files of dir, if any.
+// keyspaceLock must be held.
+func (a *Aof) startAppendOnly() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		return nil
+	}
+	// Of the AOF turned off meanwhile
+	if a.rewriting {
+		return errRewriteInProgress
+	}
+	if err := loadKeys(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.dir, os.ModePerm); err != nil {
+		return err
+	}
+	m, err := loadManifest(a.dir)
+	if err != nil {
+		return err
+	}
+	seq := m.nextIncrSeq()
+	f, s, err := openIncr(filepath.Join(a.dir, incrName(seq)), os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	m.incrs = append(m.incrs, aofFile{name: incrName(seq), seq: seq, kind: aofIncrType})
+	a.manifest, a.file, a.sealer = m, f, s
+	a.size, a.baseSize = a.filesSize()
+	a.rewriteInBackground(true)
+	a.startFsync()
+	return nil
+}
+
+// openNewIncr adds a new incremental file to the manifest and appends to it
+// from now on, a.mu must be held
+func (a *Aof) openNewIncr() error {
+	seq := a.manifest.nextIncrSeq()
+	name := incrName(seq)
+	f, s, err := openIncr(filepath.Join(a.dir, name), os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	m := *a.manifest
+	m.incrs = append(m.incrs[:len(m.incrs):len(m.incrs)], aofFile{name: name, seq: seq, kind: aofIncrType})
+	if err := m.save(a.dir); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	a.manifest = &m
+
+	if a.dirty && serverConfig.Enum("appendfsync") != "no" {
+		a.fsync(a.file)
+	}
+	a.file.Close()
+	a.file, a.sealer = f, s
+	return nil
+}
+
+// rewrite writes the new base file, then replaces the previous files by it
+// in the manifest and deletes them
+func (a *Aof) rewrite(b []byte) error {
+	a.mu.Lock()
+	seq := 1
+	if a.manifest.base != nil {
+		seq = a.manifest.base.seq + 1
+	}
+	a.mu.Unlock()
+
+	name := baseName(seq)
+	if err := writeSnapshot(b, filepath.Join(a.dir, name)); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old := *a.manifest
+	m := &aofManifest{
+		base:  &aofFile{name: name, seq: seq, kind: aofBaseType},
+		incrs: []aofFile{*old.lastIncr()},
+	}
+	if err := m.save(a.dir); err != nil {
+		os.Remove(filepath.Join(a.dir, name))
+		return err
+	}
+	a.manifest = m
+	if old.base != nil {
+		os.Remove(filepath.Join(a.dir, old.base.name))
+	}
+	for _, incr := range old.incrs[:len(old.incrs)-1] {
+		os.Remove(filepath.Join(a.dir, incr.name))
+	}
+	a.size, a.baseSize = a.filesSize()
+	a.rewrites++
+	return nil
+}
+
+// rewriteNeeded reports whether the AOF grew enough since the last rewrite
+// to start a new one, as set by auto-aof-rewrite-percentage and
+// auto-aof-rewrite-min-size
+func (a *Aof) rewriteNeeded() bool {
+	perc := serverConfig.Int("auto-aof-rewrite-percentage")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if perc == 0 || a.file == nil || a.rewriting || a.size < serverConfig.Bytes("auto-aof-rewrite-min-size") {
+		return false
+	}
+	base := max(a.baseSize, 1)
+	return (a.size-base)*100/base >= int64(perc)
+}
+
+// BGREWRITEAOF
+func bgrewriteaof(a *Aof, p []resp.Payload) resp.Payload {
+	if len(p) != 0 {
+		return missingArgumentsError
+	}
+	if err := a.startRewrite(); err != nil {
+		return resp.NewError("ERR", err.Error())
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: "Background append only file rewriting started"}
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// dataset describes every key with its type, content and expiration
+func dataset() map[string]string {
+	d := map[string]string{}
+	for key, obj := range keyspace {
+		var values []string
+		switch obj.kind {
+		case stringType:
+			values = []string{obj.value.(string)}
+		case listType:
+			values = bulks(lrange(args(key, "0", "-1")))
+		case hashType:
+			for f, v := range obj.value.(*redisHash).fields {
+				values = append(values, fmt.Sprintf("%s=%s@%d", f, v.value, v.expire.UnixMilli()))
+			}
+		case setType:
+			values = obj.value.(*redisSet).members()
+		case zsetType:
+			values = bulks(zrange(args(key, "0", "-1", "WITHSCORES")))
+		}
+		if obj.kind == hashType || obj.kind == setType {
+			sort.Strings(values)
+		}
+		d[key] = fmt.Sprint(obj.kind, values, obj.expire.UnixMilli())
+	}
+	return d
+}
+
+func waitRewrite(t *testing.T, aof *Aof) {
+	for i := 0; i < 500; i++ {
+		aof.mu.Lock()
+		rewriting := aof.rewriting
+		aof.mu.Unlock()
+		if !rewriting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("AOF rewrite did not complete")
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	for i := 0; i < 200; i++ {
+		processRequest(nil, request("RPUSH", "list", strconv.Itoa(i)), aof)
+		processRequest(nil, request("SADD", "set", "m"+strconv.Itoa(i%10)), aof)
+		processRequest(nil, request("INCR", "counter"), aof)
+	}
+	processRequest(nil, request("SADD", "ints", "1", "2", "3"), aof)
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "f2", "v2", "f3", "v3"), aof)
+	processRequest(nil, request("HPEXPIRE", "hash", "100000", "FIELDS", "2", "f1", "f2"), aof)
+	processRequest(nil, request("ZADD", "zset", "1.5", "a", "-inf", "b", "2e30", "c"), aof)
+	processRequest(nil, request("SET", "volatile", "v", "EX", "100"), aof)
+	processRequest(nil, request("SET", "expired", "v", "PX", "1"), aof)
+	time.Sleep(5 * time.Millisecond)
+	before := aof.size
+
+	if response := processRequest(nil, request("BGREWRITEAOF"), aof); response.Str != "Background append only file rewriting started" {
+		t.Fatalf("Expected rewrite to start, got %v", response)
+	}
+	// Written while the rewrite runs, to the new incremental file
+	processRequest(nil, request("RPUSH", "list", "last"), aof)
+	processRequest(nil, request("SET", "during", "rewrite"), aof)
+	waitRewrite(t, aof)
+	delete(keyspace, "expired")
+	expected := dataset()
+
+	if aof.size >= before {
+		t.Errorf("Expected the AOF to shrink, from %d to %d bytes", before, aof.size)
+	}
+	manifest, _ := os.ReadFile(manifestPath(aof.dir))
+	if want := "file database.aof.1.base.snap seq 1 type b\nfile database.aof.2.incr.aof seq 2 type i\n"; string(manifest) != want {
+		t.Errorf("Expected manifest %q, got %q", want, manifest)
+	}
+	if _, err := os.Stat(filepath.Join(aof.dir, incrName(1))); !os.IsNotExist(err) {
+		t.Errorf("Expected the previous incremental file to be deleted")
+	}
+	base, _ := os.Stat(filepath.Join(aof.dir, baseName(1)))
+	incr, _ := os.Stat(filepath.Join(aof.dir, incrName(2)))
+	if aof.rewrites != 1 || aof.baseSize != base.Size() || aof.size != base.Size()+incr.Size() {
+		t.Errorf("Unexpected rewrite state: %d rewrites, size %d, base size %d", aof.rewrites, aof.size, aof.baseSize)
+	}
+
+	// Appends keep going to the new file
+	processRequest(nil, request("SET", "after", "rewrite"), aof)
+	expected = dataset()
+
+	keyspace = map[string]*redisObject{}
+	aof.load()
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after replay, got %v", expected, got)
+	}
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	serverConfig.Set("auto-aof-rewrite-min-size", "1kb")
+	defer serverConfig.Set("auto-aof-rewrite-min-size", "64mb")
+
+	// Below the minimum size
+	processRequest(nil, request("SET", "key", "value"), aof)
+	if aof.rewrites != 0 || aof.rewriting {
+		t.Fatalf("Expected no rewrite below auto-aof-rewrite-min-size")
+	}
+	for i := 0; i < 100 && aof.rewrites == 0; i++ {
+		processRequest(nil, request("SET", "key", strconv.Itoa(i)), aof)
+		waitRewrite(t, aof)
+	}
+	if aof.rewrites != 1 {
+		t.Fatalf("Expected a rewrite once the AOF reaches auto-aof-rewrite-min-size")
+	}
+
+	// The file has to double before the next one
+	serverConfig.Set("auto-aof-rewrite-min-size", "1")
+	base := aof.baseSize
+	for aof.size < 2*base-30 {
+		processRequest(nil, request("SET", "key", "v"), aof)
+		waitRewrite(t, aof)
+	}
+	if aof.rewrites != 1 {
+		t.Errorf("Expected no rewrite before the AOF grows by auto-aof-rewrite-percentage")
+	}
+	for i := 0; i < 2; i++ {
+		processRequest(nil, request("SET", "key", "v"), aof)
+		waitRewrite(t, aof)
+	}
+	if aof.rewrites != 2 {
+		t.Errorf("Expected a second rewrite, got %d", aof.rewrites)
+	}
+}
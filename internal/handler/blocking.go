@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Blocking list operations.
+// A client that finds all its keys empty is registered on each of them and
+// parks its connection until a push on one of the keys serves it, or until
+// its timeout. Clients waiting on the same key are served in FIFO order.
+// Everything here runs with keyspaceLock held, except blockedClient.wait.
+
+var blockingHandlers = map[string]func([]resp.Payload) (*blockedClient, resp.Payload){
+	"BLPOP":      blpop,
+	"BRPOP":      brpop,
+	"BLMOVE":     blmove,
+	"BRPOPLPUSH": brpoplpush,
+}
+
+type blockedClient struct {
+	keys     []string
+	fromLeft bool
+	timeout  time.Duration
+
+	// BLMOVE pushes the popped element to destination instead of replying
+	// with the key name
+	move        bool
+	destination string
+	toLeft      bool
+
+	reply chan resp.Payload
+}
+
+// Blocked clients per key, in the order they blocked
+var blockedKeys = map[string][]*blockedClient{}
+
+// Keys that received a push while clients are blocked on them
+var readyKeys []string
+var readyKeysSet = map[string]bool{}
+
+func parseTimeout(s string) (time.Duration, resp.Payload) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, resp.NewError("ERR", "timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, resp.NewError("ERR", "timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), resp.Payload{}
+}
+
+// BLPOP key [key ...] timeout
+func blockingPop(p []resp.Payload, left bool) (*blockedClient, resp.Payload) {
+	if len(p) < 2 {
+		return nil, missingArgumentsError
+	}
+	timeout, errPayload := parseTimeout(string(p[len(p)-1].Bulk))
+	if errPayload.DataType != "" {
+		return nil, errPayload
+	}
+	bc := &blockedClient{fromLeft: left, timeout: timeout}
+	for _, k := range p[:len(p)-1] {
+		bc.keys = append(bc.keys, string(k.Bulk))
+	}
+	return bc, resp.Payload{}
+}
+
+func blpop(p []resp.Payload) (*blockedClient, resp.Payload) { return blockingPop(p, true) }
+func brpop(p []resp.Payload) (*blockedClient, resp.Payload) { return blockingPop(p, false) }
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func blmove(p []resp.Payload) (*blockedClient, resp.Payload) {
+	if len(p) != 5 {
+		return nil, missingArgumentsError
+	}
+	fromLeft, ok1 := parseListEnd(string(p[2].Bulk))
+	toLeft, ok2 := parseListEnd(string(p[3].Bulk))
+	if !ok1 || !ok2 {
+		return nil, syntaxError
+	}
+	timeout, errPayload := parseTimeout(string(p[4].Bulk))
+	if errPayload.DataType != "" {
+		return nil, errPayload
+	}
+	return &blockedClient{
+		keys:        []string{string(p[0].Bulk)},
+		fromLeft:    fromLeft,
+		timeout:     timeout,
+		move:        true,
+		destination: string(p[1].Bulk),
+		toLeft:      toLeft,
+	}, resp.Payload{}
+}
+
+func brpoplpush(p []resp.Payload) (*blockedClient, resp.Payload) {
+	if len(p) != 3 {
+		return nil, missingArgumentsError
+	}
+	return blmove([]resp.Payload{p[0], p[1], {Bulk: []byte("RIGHT")}, {Bulk: []byte("LEFT")}, p[2]})
+}
+
+func listEndName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// serve pops an element from key on behalf of the client. It returns false
+// when key does not hold a non empty list. The returned command is the non
+// blocking equivalent to append to the AOF.
+func (bc *blockedClient) serve(key string) (resp.Payload, *resp.Payload, bool) {
+	obj, ok := lookupTyped(key, listType)
+	if !ok || obj == nil {
+		return resp.Payload{}, nil, false
+	}
+
+	if bc.move {
+		v, _, errPayload := listMove(key, bc.destination, bc.fromLeft, bc.toLeft)
+		if errPayload.DataType != "" {
+			return errPayload, nil, true
+		}
+		cmd := bulkArray([]string{"LMOVE", key, bc.destination, listEndName(bc.fromLeft), listEndName(bc.toLeft)})
+		return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(v)}, &cmd, true
+	}
+
+	l := obj.value.(*quicklist)
+	v, _ := popListEnd(l, bc.fromLeft)
+	dirty++
+	if l.len() == 0 {
+		delete(keyspace, key)
+	}
+	cmd := bulkArray([]string{"RPOP", key})
+	if bc.fromLeft {
+		cmd = bulkArray([]string{"LPOP", key})
+	}
+	return bulkArray([]string{key, v}), &cmd, true
+}
+
+func blockClient(bc *blockedClient) {
+	bc.reply = make(chan resp.Payload, 1)
+	for _, key := range bc.keys {
+		blockedKeys[key] = append(blockedKeys[key], bc)
+	}
+}
+
+func unblockClient(bc *blockedClient) {
+	for _, key := range bc.keys {
+		clients := blockedKeys[key]
+		for i, c := range clients {
+			if c == bc {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(blockedKeys, key)
+		} else {
+			blockedKeys[key] = clients
+		}
+	}
+}
+
+// signalKeyAsReady is called by every command that pushes to a list
+func signalKeyAsReady(key string) {
+	if len(blockedKeys[key]) == 0 || readyKeysSet[key] {
+		return
+	}
+	readyKeysSet[key] = true
+	readyKeys = append(readyKeys, key)
+}
+
+// handleClientsBlockedOnKeys serves clients blocked on keys that were pushed
+// to by the last command. Serving a BLMOVE may push to another key, which is
+// then handled in the same loop.
+func handleClientsBlockedOnKeys(aof *Aof) {
+	for len(readyKeys) > 0 {
+		key := readyKeys[0]
+		readyKeys = readyKeys[1:]
+		delete(readyKeysSet, key)
+
+		for len(blockedKeys[key]) > 0 {
+			bc := blockedKeys[key][0]
+			reply, cmd, ok := bc.serve(key)
+			if !ok {
+				break
+			}
+			unblockClient(bc)
+			if cmd != nil {
+				if err := propagate(aof, cmd); err != nil {
+					reply = aofWriteError(err)
+				}
+			}
+			bc.reply <- reply
+		}
+	}
+}
+
+// wait parks the caller until the client is served, times out or closed is
+// signaled. It must be called without keyspaceLock held.
+func (bc *blockedClient) wait(closed <-chan struct{}) resp.Payload {
+	var timeout <-chan time.Time
+	if bc.timeout > 0 {
+		timer := time.NewTimer(bc.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case reply := <-bc.reply:
+		return reply
+	case <-timeout:
+	case <-closed:
+	}
+
+	keyspaceLock.Lock()
+	defer keyspaceLock.Unlock()
+	// The client may have been served while waiting for the lock
+	select {
+	case reply := <-bc.reply:
+		return reply
+	default:
+	}
+	unblockClient(bc)
+	// BLPOP and BRPOP time out with a null array, BLMOVE with a null bulk
+	if bc.move {
+		return resp.NullBulk
+	}
+	return resp.NullArray
+}
+
+// processBlockingRequest serves the command right away when one of its keys
+// holds a list, otherwise it blocks the client.
+func processBlockingRequest(c *client, request string, params []resp.Payload, aof *Aof) resp.Payload {
+	keyspaceLock.Lock()
+	if err := aof.writeErr(); err != nil {
+		keyspaceLock.Unlock()
+		return aofWriteError(err)
+	}
+	bc, errPayload := blockingHandlers[request](params)
+	if errPayload.DataType != "" {
+		keyspaceLock.Unlock()
+		return errPayload
+	}
+
+	keys := bc.keys
+	if bc.move {
+		keys = append(keys, bc.destination)
+	}
+	for _, key := range keys {
+		if _, ok := lookupTyped(key, listType); !ok {
+			keyspaceLock.Unlock()
+			return wrongTypeError
+		}
+	}
+	for _, key := range bc.keys {
+		if reply, cmd, ok := bc.serve(key); ok {
+			if cmd != nil {
+				if err := propagate(aof, cmd); err != nil {
+					reply = aofWriteError(err)
+				}
+			}
+			handleClientsBlockedOnKeys(aof)
+			keyspaceLock.Unlock()
+			return reply
+		}
+	}
+	blockClient(bc)
+	keyspaceLock.Unlock()
+
+	var closed <-chan struct{}
+	if c != nil {
+		// Replies of the commands pipelined before are not held while blocked
+		c.writer.Flush()
+		var stop func()
+		closed, stop = c.watchDisconnect()
+		defer stop()
+	}
+	return bc.wait(closed)
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// waitBlocked waits until n clients are blocked on key
+func waitBlocked(t *testing.T, key string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		keyspaceLock.Lock()
+		blocked := len(blockedKeys[key])
+		keyspaceLock.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d clients blocked on %s", n, key)
+}
+
+func blockingRequest(aof *Aof, values ...string) <-chan resp.Payload {
+	replies := make(chan resp.Payload, 1)
+	go func() {
+		replies <- processRequest(nil, request(values...), aof)
+	}()
+	return replies
+}
+
+func TestBlpopImmediate(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("RPUSH", "list2", "a"), aof)
+	response := processRequest(nil, request("BLPOP", "list1", "list2", "0"), aof)
+	if got := bulks(response); !reflect.DeepEqual(got, []string{"list2", "a"}) {
+		t.Errorf("Expected [list2 a], got %v", got)
+	}
+
+	set(args("str", "value"))
+	response = processRequest(nil, request("BLPOP", "str", "0"), aof)
+	if response.Str != wrongTypeError.Str {
+		t.Errorf("Expected WRONGTYPE, got %v", response)
+	}
+}
+
+func TestBlockingAofWriteError(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	popper := blockingRequest(aof, "BLPOP", "jobs", "0")
+	waitBlocked(t, "jobs", 1)
+	aof.file.Close()
+	if response := processRequest(nil, request("RPUSH", "jobs", "a", "b"), aof); response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected a MISCONF error when the write fails, got %v", response)
+	}
+	// The served client is told its pop was not logged
+	if response := <-popper; response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected a MISCONF error for the served client, got %v", response)
+	}
+	if response := processRequest(nil, request("BLPOP", "jobs", "0"), aof); response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected blocking pops to be refused, got %v", response)
+	}
+	if got := bulks(lrange(args("jobs", "0", "-1"))); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Expected [b] left, got %v", got)
+	}
+}
+
+func TestBlpopServedInOrder(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	first := blockingRequest(aof, "BLPOP", "queue", "0")
+	waitBlocked(t, "queue", 1)
+	second := blockingRequest(aof, "BRPOP", "other", "queue", "0")
+	waitBlocked(t, "queue", 2)
+
+	processRequest(nil, request("RPUSH", "queue", "job1"), aof)
+	if got := bulks(<-first); !reflect.DeepEqual(got, []string{"queue", "job1"}) {
+		t.Errorf("Expected [queue job1], got %v", got)
+	}
+	processRequest(nil, request("RPUSH", "queue", "job2"), aof)
+	if got := bulks(<-second); !reflect.DeepEqual(got, []string{"queue", "job2"}) {
+		t.Errorf("Expected [queue job2], got %v", got)
+	}
+	if len(blockedKeys) != 0 || len(blockedKeys["other"]) != 0 {
+		t.Errorf("Expected no blocked client, got %v", blockedKeys)
+	}
+
+	// The served pops are replayed as plain pops
+	keyspace = map[string]*redisObject{}
+	aof.load()
+	if exist(args("queue")).Num != 0 {
+		t.Errorf("Expected queue to be empty after replay")
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	start := time.Now()
+	response := processRequest(nil, request("BLPOP", "empty", "0.05"), aof)
+	if !response.Null || response.DataType != string(resp.ARRAY) {
+		t.Errorf("Expected nil, got %v", response)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected to block for the timeout")
+	}
+	if len(blockedKeys) != 0 {
+		t.Errorf("Expected client to be unblocked, got %v", blockedKeys)
+	}
+
+	response = processRequest(nil, request("BLPOP", "empty", "-1"), aof)
+	if response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+}
+
+func TestBlmove(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	// A BLMOVE wakes up clients blocked on its destination
+	mover := blockingRequest(aof, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlocked(t, "src", 1)
+	popper := blockingRequest(aof, "BLPOP", "dst", "0")
+	waitBlocked(t, "dst", 1)
+
+	processRequest(nil, request("LPUSH", "src", "a"), aof)
+	if response := <-mover; string(response.Bulk) != "a" {
+		t.Errorf("Expected a, got %v", response)
+	}
+	if got := bulks(<-popper); !reflect.DeepEqual(got, []string{"dst", "a"}) {
+		t.Errorf("Expected [dst a], got %v", got)
+	}
+	if exist(args("src", "dst")).Num != 0 {
+		t.Errorf("Expected src and dst to be empty")
+	}
+}
+
+func TestBlockedClientDisconnect(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(server, aof)
+		close(done)
+	}()
+	conn.Write(request("BLPOP", "jobs", "0").Write())
+	waitBlocked(t, "jobs", 1)
+
+	conn.Close()
+	<-done
+	waitBlocked(t, "jobs", 0)
+
+	// The element pushed afterwards is not lost
+	processRequest(nil, request("RPUSH", "jobs", "job"), aof)
+	if response := llen(args("jobs")); response.Num != 1 {
+		t.Errorf("Expected 1, got %d", response.Num)
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// client holds the state of a connection
+type client struct {
+	id     int64
+	name   string
+	conn   net.Conn
+	reader *resp.RespReader
+	writer *resp.RespWriter
+	// Protocol version negotiated with HELLO, 2 by default
+	proto         int
+	authenticated bool
+}
+
+var lastClientID atomic.Int64
+
+func newClient(conn net.Conn) *client {
+	password, _ := serverConfig.Get("requirepass")
+	c := &client{
+		id:            lastClientID.Add(1),
+		conn:          conn,
+		reader:        resp.NewRespReader(conn),
+		writer:        resp.NewRespWriter(conn),
+		proto:         2,
+		authenticated: password == "",
+	}
+	c.reader.SetMaxBulkLen(serverConfig.Bytes("proto-max-bulk-len"))
+	return c
+}
+
+// Commands working on the state of the connection rather than on the dataset
+var clientHandlers = map[string]func(*client, []resp.Payload) resp.Payload{
+	"HELLO": hello,
+	"AUTH":  auth,
+}
+
+var noAuthError = resp.NewError("NOAUTH", "Authentication required.")
+var wrongPassError = resp.NewError("WRONGPASS", "invalid username-password pair or user is disabled.")
+
+// checkPassword validates the credentials of the default user, the only one
+// there is. Any password is accepted when requirepass is not set.
+func checkPassword(username, password string) bool {
+	required, _ := serverConfig.Get("requirepass")
+	if username != "default" {
+		return false
+	}
+	return required == "" || subtle.ConstantTimeCompare([]byte(password), []byte(required)) == 1
+}
+
+// AUTH [username] password
+func auth(c *client, p []resp.Payload) resp.Payload {
+	username := "default"
+	switch len(p) {
+	case 1:
+		if required, _ := serverConfig.Get("requirepass"); required == "" {
+			return resp.NewError("ERR", "AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+	case 2:
+		username = string(p[0].Bulk)
+	default:
+		return syntaxError
+	}
+	if !checkPassword(username, string(p[len(p)-1].Bulk)) {
+		return wrongPassError
+	}
+	c.authenticated = true
+	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// Switches the connection to RESP3 with protover 3, the reply describing the
+// server is sent with the new protocol.
+func hello(c *client, p []resp.Payload) resp.Payload {
+	proto := c.proto
+	if len(p) > 0 {
+		v, err := strconv.Atoi(string(p[0].Bulk))
+		if err != nil {
+			return resp.NewError("ERR", "Protocol version is not an integer or out of range")
+		}
+		if v != 2 && v != 3 {
+			return resp.NewError("NOPROTO", "unsupported protocol version")
+		}
+		proto = v
+	}
+
+	authenticated, name := c.authenticated, c.name
+	for i := 1; i < len(p); i++ {
+		option := strings.ToUpper(string(p[i].Bulk))
+		switch {
+		case option == "AUTH" && i+2 < len(p):
+			if !checkPassword(string(p[i+1].Bulk), string(p[i+2].Bulk)) {
+				return wrongPassError
+			}
+			authenticated = true
+			i += 2
+		case option == "SETNAME" && i+1 < len(p):
+			name = string(p[i+1].Bulk)
+			if strings.ContainsAny(name, " \n") {
+				return resp.NewError("ERR", "Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return resp.NewError("ERR", "Syntax error in HELLO option '"+string(p[i].Bulk)+"'")
+		}
+	}
+	if !authenticated {
+		return resp.NewError("NOAUTH", "HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	c.authenticated, c.name, c.proto = authenticated, name, proto
+	if c.writer != nil {
+		c.writer.SetProtocol(proto)
+	}
+	return resp.Payload{DataType: string(resp.MAP), Array: []resp.Payload{
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("server")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("redis")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("version")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte(redisVersion)},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("proto")},
+		{DataType: string(resp.INTEGER), Num: proto},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("id")},
+		{DataType: string(resp.INTEGER), Num: int(c.id)},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("mode")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("standalone")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("role")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("master")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("modules")},
+		{DataType: string(resp.ARRAY), Array: []resp.Payload{}},
+	}}
+}
+
+// watchDisconnect reports on the returned channel when the peer closes the
+// connection, which is used to unblock clients waiting on a key.
+// stop must be called before reading from the connection again.
+func (c *client) watchDisconnect() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Data sent meanwhile stays buffered in the reader for the next read
+		_, err := c.reader.Peek(1)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(closed)
+		}
+	}()
+
+	stop := func() {
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	return closed, stop
+}
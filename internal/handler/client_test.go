@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// connect starts a connection handler and returns the client side of it
+func connect(t *testing.T) (net.Conn, *resp.RespReader) {
+	aof := newTestAof(t)
+	server, conn := net.Pipe()
+	go HandleConnection(server, aof)
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.NewRespReader(conn)
+}
+
+func send(t *testing.T, conn net.Conn, reader *resp.RespReader, values ...string) resp.Payload {
+	conn.Write(request(values...).Write())
+	response, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestHello(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	conn, reader := connect(t)
+
+	send(t, conn, reader, "HSET", "hash", "f", "v")
+	send(t, conn, reader, "ZADD", "zset", "1.5", "m")
+	if response := send(t, conn, reader, "HGETALL", "hash"); response.DataType != string(resp.ARRAY) {
+		t.Errorf("Expected array with RESP2, got %v", response)
+	}
+
+	response := send(t, conn, reader, "HELLO", "3", "SETNAME", "test")
+	if response.DataType != string(resp.MAP) || len(response.Array) != 14 {
+		t.Fatalf("Expected map, got %v", response)
+	}
+	if string(response.Array[4].Bulk) != "proto" || response.Array[5].Num != 3 {
+		t.Errorf("Expected proto 3, got %v", response.Array[4:6])
+	}
+	if response := send(t, conn, reader, "HGETALL", "hash"); response.DataType != string(resp.MAP) {
+		t.Errorf("Expected map with RESP3, got %v", response)
+	}
+	if response := send(t, conn, reader, "ZSCORE", "zset", "m"); response.DataType != string(resp.DOUBLE) || response.Double != 1.5 {
+		t.Errorf("Expected double with RESP3, got %v", response)
+	}
+
+	if response := send(t, conn, reader, "HELLO", "4"); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+	send(t, conn, reader, "HELLO", "2")
+	if response := send(t, conn, reader, "ZSCORE", "zset", "m"); response.DataType != string(resp.BULKSTRING) || string(response.Bulk) != "1.5" {
+		t.Errorf("Expected bulk string with RESP2, got %v", response)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	serverConfig.Set("requirepass", "secret")
+	defer serverConfig.Set("requirepass", "")
+	conn, reader := connect(t)
+
+	if response := send(t, conn, reader, "PING"); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected NOAUTH, got %v", response)
+	}
+	if response := send(t, conn, reader, "HELLO", "3"); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected NOAUTH, got %v", response)
+	}
+	if response := send(t, conn, reader, "AUTH", "wrong"); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected WRONGPASS, got %v", response)
+	}
+	if response := send(t, conn, reader, "HELLO", "3", "AUTH", "default", "secret"); response.DataType != string(resp.MAP) {
+		t.Errorf("Expected map, got %v", response)
+	}
+	if response := send(t, conn, reader, "PING"); response.Str != "PONG" {
+		t.Errorf("Expected PONG, got %v", response)
+	}
+
+	conn, reader = connect(t)
+	if response := send(t, conn, reader, "AUTH", "secret"); response.Str != "OK" {
+		t.Errorf("Expected OK, got %v", response)
+	}
+}
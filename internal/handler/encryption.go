@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encryption at rest of the AOF and snapshot files, with AES-GCM, when
+// encryption-key-file is set. The key file holds hex encoded AES keys of 16,
+// 24 or 32 bytes, one per line. The last one encrypts, all of them decrypt,
+// so keys are rotated by adding a new key at the end of the file: the AOF
+// rewrite reads the key file again, and the new base and incremental files
+// are encrypted with the new key. Once the rewrite completed, and a snapshot
+// was saved, the previous keys can be removed.
+//
+// An encrypted file is a header followed by records, each one encrypted on
+// its own so that commands are appended to the AOF one record at a time:
+//
+//	header: "RLSEALED" | file id (8 bytes)
+//	record: key id (4 bytes) | length (4 bytes) | nonce (12 bytes) | ciphertext
+//
+// The file id and the index of the record in the file are authenticated
+// along with the record, so records can't be moved, dropped or replayed from
+// another file without failing the decryption, only a truncation at the end
+// of a record goes unnoticed. A torn write at the end of the AOF is dropped
+// at startup the same way as in plain files.
+//
+// The nonce of a record is the file id followed by the index of the record,
+// so a key never encrypts two records with the same nonce as long as file
+// ids, random, don't collide: the chance is below 2^-32 under 2^16 files
+// per key, rotating keys keeps far from it. A file holds at most 2^32
+// records, past that commands fail to be appended until BGREWRITEAOF starts
+// a new file. After a torn record is dropped, commands are appended to a new
+// file rather than reuse its nonce.
+
+const sealedMagic = "RLSEALED"
+
+const (
+	sealedHeaderSize = len(sealedMagic) + 8
+	recordHeaderSize = 4 + 4 + 12
+	// Snapshots are split into records of this size
+	maxRecordSize = 1024 * 1024
+)
+
+type encryptionKey struct {
+	id   [4]byte
+	aead cipher.AEAD
+}
+
+type keyring struct {
+	current *encryptionKey
+	byID    map[[4]byte]*encryptionKey
+}
+
+// The keys of encryption-key-file, nil when encryption is disabled
+var (
+	keys     *keyring
+	keysLock sync.RWMutex
+)
+
+func currentKeys() *keyring {
+	keysLock.RLock()
+	defer keysLock.RUnlock()
+	return keys
+}
+
+// loadKeys reads the keys of the encryption-key-file setting, at startup and
+// at every AOF rewrite so that keys are rotated
+func loadKeys() error {
+	name, _ := serverConfig.Get("encryption-key-file")
+	return LoadEncryptionKeys(name)
+}
+
+// LoadEncryptionKeys reads the keys of a key file, an empty name disabling
+// encryption
+func LoadEncryptionKeys(name string) error {
+	var k *keyring
+	if name != "" {
+		var err error
+		if k, err = readKeyFile(name); err != nil {
+			return err
+		}
+	}
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	keys = k
+	return nil
+}
+
+func readKeyFile(name string) (*keyring, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &keyring{byID: map[[4]byte]*encryptionKey{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		secret, err := hex.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key must be hex encoded", name, line)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key must be 16, 24 or 32 bytes long", name, line)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		key := &encryptionKey{aead: aead}
+		sum := sha256.Sum256(secret)
+		copy(key.id[:], sum[:])
+		k.byID[key.id] = key
+		k.current = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == nil {
+		return nil, fmt.Errorf("%s: no key found", name)
+	}
+	return k, nil
+}
+
+func isSealed(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(sealedMagic))
+}
+
+// sealer encrypts the records of a file with a key
+type sealer struct {
+	key    *encryptionKey
+	fileID [8]byte
+	index  uint64
+}
+
+// newSealer starts a new file encrypted with the current key, it returns nil
+// when encryption is disabled
+func newSealer() *sealer {
+	k := currentKeys()
+	if k == nil {
+		return nil
+	}
+	s := &sealer{key: k.current}
+	rand.Read(s.fileID[:])
+	return s
+}
+
+func (s *sealer) header() []byte {
+	return append([]byte(sealedMagic), s.fileID[:]...)
+}
+
+// Records of a file, the index is the last 4 bytes of their nonce
+const maxRecords = 1 << 32
+
+var errTooManyRecords = errors.New("encrypted file is full, BGREWRITEAOF starts a new one")
+
+// seal appends the record encrypting plain to dst
+func (s *sealer) seal(dst, plain []byte) ([]byte, error) {
+	if s.index >= maxRecords {
+		return dst, errTooManyRecords
+	}
+	var nonce [12]byte
+	copy(nonce[:], s.fileID[:])
+	binary.BigEndian.PutUint32(nonce[8:], uint32(s.index))
+	dst = append(dst, s.key.id[:]...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(plain)+s.key.aead.Overhead()))
+	dst = append(dst, nonce[:]...)
+	dst = s.key.aead.Seal(dst, nonce[:], plain, recordData(s.fileID, s.index))
+	s.index++
+	return dst, nil
+}
+
+// recordData is the additional data authenticated with a record
+func recordData(fileID [8]byte, index uint64) []byte {
+	return binary.BigEndian.AppendUint64(fileID[:], index)
+}
+
+// sealFile encrypts the content of a file with the current key, the content
+// is returned as is when encryption is disabled
+func sealFile(b []byte) []byte {
+	s := newSealer()
+	if s == nil {
+		return b
+	}
+	out := s.header()
+	for len(b) > 0 {
+		n := min(len(b), maxRecordSize)
+		// A snapshot is far from the limit of records
+		out, _ = s.seal(out, b[:n])
+		b = b[n:]
+	}
+	return out
+}
+
+// unsealRecords decrypts the records of an encrypted file, calling fn for
+// each of them. It returns the offset of the end of the last valid record
+// along with the error that stopped reading, io.ErrUnexpectedEOF when the
+// file ends with a partial record.
+func unsealRecords(b []byte, fn func(record []byte) error) (int64, error) {
+	if len(b) < sealedHeaderSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	k := currentKeys()
+	if k == nil {
+		return 0, errors.New("encrypted file, encryption-key-file must be set")
+	}
+	var fileID [8]byte
+	copy(fileID[:], b[len(sealedMagic):])
+	offset := sealedHeaderSize
+	for index := uint64(0); offset < len(b); index++ {
+		if len(b)-offset < recordHeaderSize {
+			return int64(offset), io.ErrUnexpectedEOF
+		}
+		var id [4]byte
+		copy(id[:], b[offset:])
+		size := int(binary.BigEndian.Uint32(b[offset+4:]))
+		nonce := b[offset+8 : offset+recordHeaderSize]
+		if len(b)-offset-recordHeaderSize < size {
+			return int64(offset), io.ErrUnexpectedEOF
+		}
+		key, ok := k.byID[id]
+		if !ok {
+			return int64(offset), fmt.Errorf("record encrypted with unknown key %x", id)
+		}
+		ciphertext := b[offset+recordHeaderSize : offset+recordHeaderSize+size]
+		plain, err := key.aead.Open(nil, nonce, ciphertext, recordData(fileID, index))
+		if err != nil {
+			return int64(offset), errors.New("record failed authentication")
+		}
+		if err := fn(plain); err != nil {
+			return int64(offset), err
+		}
+		offset += recordHeaderSize + size
+	}
+	return int64(offset), nil
+}
+
+// unsealFile decrypts the content of a whole file, b is returned as is when
+// it is not encrypted
+func unsealFile(b []byte) ([]byte, error) {
+	if !isSealed(b) {
+		return b, nil
+	}
+	var out []byte
+	_, err := unsealRecords(b, func(record []byte) error {
+		out = append(out, record...)
+		return nil
+	})
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	return out, err
+}
+
+// resumeSealer continues appending records to an encrypted file, with the
+// current key
+func resumeSealer(f *os.File) (*sealer, error) {
+	k := currentKeys()
+	if k == nil {
+		return nil, errors.New("encrypted file, encryption-key-file must be set")
+	}
+	s := &sealer{key: k.current}
+	header := make([]byte, sealedHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	copy(s.fileID[:], header[len(sealedMagic):])
+	offset := int64(sealedHeaderSize)
+	recordHeader := make([]byte, recordHeaderSize)
+	for {
+		_, err := f.ReadAt(recordHeader, offset)
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(recordHeaderSize) + int64(binary.BigEndian.Uint32(recordHeader[4:]))
+		s.index++
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f"
+	testKey2 = "f0e0d0c0b0a090807060504030201000"
+)
+
+// useKeys enables encryption with a key file holding keys
+func useKeys(t *testing.T, keys ...string) string {
+	name := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(name, []byte(strings.Join(keys, "\n")), 0600)
+	setConfig(t, "encryption-key-file", name)
+	if err := loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadEncryptionKeys("") })
+	return name
+}
+
+func keyID(key string) []byte {
+	secret, _ := hex.DecodeString(key)
+	sum := sha256.Sum256(secret)
+	return sum[:4]
+}
+
+func TestKeyFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keys")
+	for _, invalid := range []string{"", "# no key\n", "not hex\n", "0011\n"} {
+		os.WriteFile(name, []byte(invalid), 0600)
+		if err := LoadEncryptionKeys(name); err == nil {
+			t.Errorf("Expected an error loading %q", invalid)
+		}
+	}
+	os.WriteFile(name, []byte("# old\n"+testKey1+"\n\n"+testKey2+"\n"), 0600)
+	if err := LoadEncryptionKeys(name); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadEncryptionKeys("")
+	if k := currentKeys(); len(k.byID) != 2 || !bytes.Equal(k.current.id[:], keyID(testKey2)) {
+		t.Errorf("Expected the last key to be the current one")
+	}
+}
+
+func TestEncryptedAof(t *testing.T) {
+	useKeys(t, testKey1)
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "session", "secret-value"), aof)
+	processRequest(nil, request("RPUSH", "list", "a", "b"), aof)
+	processRequest(nil, request("HSET", "hash", "f", "v"), aof)
+	expected := dataset()
+	aof.Close()
+
+	name := filepath.Join(aof.dir, incrName(1))
+	b, _ := os.ReadFile(name)
+	if !isSealed(b) || bytes.Contains(b, []byte("secret-value")) {
+		t.Fatalf("Expected an encrypted AOF, got %q", b)
+	}
+	openTestAof(t, aof.dir).Close()
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after restart, got %v", expected, got)
+	}
+
+	// Appended after restart, a torn record is dropped
+	aof = openTestAof(t, aof.dir)
+	processRequest(nil, request("SET", "other", "1"), aof)
+	expected = dataset()
+	aof.Close()
+	b, _ = os.ReadFile(name)
+	os.WriteFile(name, append(b, b[sealedHeaderSize:sealedHeaderSize+30]...), 0666)
+	openTestAof(t, aof.dir).Close()
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after dropping the torn record, got %v", expected, got)
+	}
+
+	// Records can't be altered or moved
+	tampered := append([]byte{}, b...)
+	tampered[len(tampered)-40] ^= 1
+	os.WriteFile(name, tampered, 0666)
+	if _, err := openAof(aof.dir); err == nil || !strings.Contains(err.Error(), "authentication") {
+		t.Errorf("Expected an altered record to be refused, got %v", err)
+	}
+	first := sealedHeaderSize + recordHeaderSize + len(request("SET", "session", "secret-value").Write()) + 16
+	moved := append(append(append([]byte{}, b[:sealedHeaderSize]...), b[first:]...), b[sealedHeaderSize:first]...)
+	os.WriteFile(name, moved, 0666)
+	if _, err := openAof(aof.dir); err == nil || !strings.Contains(err.Error(), "corrupted at offset 16") {
+		t.Errorf("Expected moved records to be refused, got %v", err)
+	}
+
+	// Not readable without the key
+	os.WriteFile(name, b, 0666)
+	LoadEncryptionKeys("")
+	if _, err := openAof(aof.dir); err == nil {
+		t.Errorf("Expected an error without the key")
+	}
+}
+
+func TestRecordNonces(t *testing.T) {
+	useKeys(t, testKey1)
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "a", "1"), aof)
+	processRequest(nil, request("SET", "b", "1"), aof)
+
+	// The file id followed by the index of the record
+	b, _ := os.ReadFile(aof.file.Name())
+	offset := sealedHeaderSize
+	for index := byte(0); offset < len(b); index++ {
+		nonce := b[offset+8 : offset+recordHeaderSize]
+		if !bytes.Equal(nonce[:8], b[len(sealedMagic):sealedHeaderSize]) || !bytes.Equal(nonce[8:], []byte{0, 0, 0, index}) {
+			t.Errorf("Unexpected nonce %x for record %d", nonce, index)
+		}
+		offset += recordHeaderSize + int(binary.BigEndian.Uint32(b[offset+4:]))
+	}
+
+	// A full file takes a rewrite
+	aof.sealer.index = maxRecords
+	if response := processRequest(nil, request("SET", "c", "1"), aof); response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected an error past the records of a file, got %v", response)
+	}
+	processRequest(nil, request("BGREWRITEAOF"), aof)
+	waitRewrite(t, aof)
+	if response := processRequest(nil, request("SET", "c", "2"), aof); response.Str != "OK" {
+		t.Errorf("Expected the new file to be appended to, got %v", response)
+	}
+
+	// A torn record is not appended after
+	name := aof.file.Name()
+	aof.Close()
+	b, _ = os.ReadFile(name)
+	os.WriteFile(name, append(b, b[sealedHeaderSize:sealedHeaderSize+30]...), 0666)
+	aof = openTestAof(t, aof.dir)
+	if aof.file.Name() == name {
+		t.Errorf("Expected a new file after dropping a torn record")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	keyFile := useKeys(t, testKey1)
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "key", "1"), aof)
+
+	os.WriteFile(keyFile, []byte(testKey1+"\n"+testKey2+"\n"), 0600)
+	processRequest(nil, request("BGREWRITEAOF"), aof)
+	waitRewrite(t, aof)
+	processRequest(nil, request("SET", "key", "2"), aof)
+	expected := dataset()
+	aof.Close()
+
+	for _, name := range []string{baseName(1), incrName(2)} {
+		b, _ := os.ReadFile(filepath.Join(aof.dir, name))
+		if !isSealed(b) || !bytes.Equal(b[sealedHeaderSize:sealedHeaderSize+4], keyID(testKey2)) {
+			t.Errorf("Expected %s to be encrypted with the new key", name)
+		}
+	}
+
+	// The previous key is not needed anymore
+	os.WriteFile(keyFile, []byte(testKey2+"\n"), 0600)
+	loadKeys()
+	openTestAof(t, aof.dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v with the new key only, got %v", expected, got)
+	}
+}
+
+func TestEncryptionEnabled(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "plain", "1"), aof)
+	aof.Close()
+
+	// Commands are not appended to the plain file anymore
+	useKeys(t, testKey1)
+	aof = openTestAof(t, aof.dir)
+	processRequest(nil, request("SET", "sealed", "1"), aof)
+	expected := dataset()
+	aof.Close()
+	if b, _ := os.ReadFile(filepath.Join(aof.dir, incrName(1))); isSealed(b) || bytes.Contains(b, []byte("sealed")) {
+		t.Errorf("Expected the previous file to be left plain, got %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(aof.dir, incrName(2))); !isSealed(b) {
+		t.Errorf("Expected a new encrypted file, got %q", b)
+	}
+	openTestAof(t, aof.dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after restart, got %v", expected, got)
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	aof := newTestAof(t)
+	name := useSnapshotPath(t)
+	useKeys(t, testKey2)
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "session", "secret-value"), aof)
+	processRequest(nil, request("SAVE"), aof)
+	expected := dataset()
+
+	b, _ := os.ReadFile(name)
+	if !isSealed(b) || bytes.Contains(b, []byte("secret-value")) {
+		t.Fatalf("Expected an encrypted snapshot, got %q", b)
+	}
+	keyspace = map[string]*redisObject{}
+	if err := loadSnapshot(name); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after loading, got %v", expected, got)
+	}
+	if report, err := CheckAof(name, false); err != nil {
+		t.Errorf("Expected a valid snapshot, got %v\n%s", err, report)
+	}
+
+	os.WriteFile(name, b[:len(b)-1], 0666)
+	if err := loadSnapshot(name); err == nil {
+		t.Errorf("Expected an error on a truncated snapshot")
+	}
+	if report, err := CheckAof(name, true); err == nil || !strings.Contains(report, "can't be fixed") {
+		t.Errorf("Expected a truncated snapshot to be reported, got %v\n%s", err, report)
+	}
+}
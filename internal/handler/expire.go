@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Key expiration, working on any type of value.
+// EXPIRE key seconds [NX | XX | GT | LT]
+// Relative times are converted to PEXPIREAT before the command is logged and
+// executed, see aofRewrites.
+
+// parseKeyExpireTime returns the absolute expiration time in milliseconds.
+// Unlike hash fields, a key accepts negative times which expire it right away.
+func parseKeyExpireTime(s string, unit time.Duration, absolute bool, now time.Time) (int64, resp.Payload) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, notIntegerError
+	}
+	if v < 0 {
+		return 0, resp.Payload{}
+	}
+	ms, ok := parseExpireTime(s, unit, absolute, now)
+	if !ok {
+		return 0, resp.NewError("ERR", "invalid expire time in 'expire' command")
+	}
+	return ms, resp.Payload{}
+}
+
+// parseExpireConditions parses the NX, XX, GT and LT flags. XX can be combined
+// with GT or LT, every other combination is rejected.
+func parseExpireConditions(p []resp.Payload) ([]string, resp.Payload) {
+	var nx, xx, gt, lt bool
+	conditions := make([]string, 0, len(p))
+	for _, arg := range p {
+		c := strings.ToUpper(string(arg.Bulk))
+		switch c {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return nil, resp.NewError("ERR", "Unsupported option "+string(arg.Bulk))
+		}
+		conditions = append(conditions, c)
+	}
+	if nx && (xx || gt || lt) {
+		return nil, resp.NewError("ERR", "NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return nil, resp.NewError("ERR", "GT and LT options at the same time are not compatible")
+	}
+	return conditions, resp.Payload{}
+}
+
+func expireGeneric(p []resp.Payload, unit time.Duration, absolute bool) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	now := time.Now()
+	ms, errPayload := parseKeyExpireTime(string(p[1].Bulk), unit, absolute, now)
+	if errPayload.DataType != "" {
+		return errPayload
+	}
+	conditions, errPayload := parseExpireConditions(p[2:])
+	if conditions == nil {
+		return errPayload
+	}
+
+	obj := lookupKey(string(p[0].Bulk))
+	if obj == nil {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	when := time.UnixMilli(ms)
+	for _, c := range conditions {
+		if !expireAllowed(c, obj.expire, when) {
+			return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+		}
+	}
+	if when.After(now) {
+		setExpire(string(p[0].Bulk), obj, when)
+	} else {
+		delete(keyspace, string(p[0].Bulk))
+		dirty++
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: 1}
+}
+
+func expire(p []resp.Payload) resp.Payload    { return expireGeneric(p, time.Second, false) }
+func pexpire(p []resp.Payload) resp.Payload   { return expireGeneric(p, time.Millisecond, false) }
+func expireat(p []resp.Payload) resp.Payload  { return expireGeneric(p, time.Second, true) }
+func pexpireat(p []resp.Payload) resp.Payload { return expireGeneric(p, time.Millisecond, true) }
+
+// keyTTL replies -2 when the key does not exist, -1 when it has no expiration
+// and the value computed from the expiration otherwise
+func keyTTL(p []resp.Payload, value func(expire time.Time) int) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	code := -2
+	if obj := lookupKey(string(p[0].Bulk)); obj != nil {
+		code = -1
+		if !obj.expire.IsZero() {
+			code = value(obj.expire)
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: code}
+}
+
+func ttl(p []resp.Payload) resp.Payload {
+	return keyTTL(p, func(expire time.Time) int {
+		return int((time.Until(expire) + 500*time.Millisecond) / time.Second)
+	})
+}
+
+func pttl(p []resp.Payload) resp.Payload {
+	return keyTTL(p, func(expire time.Time) int { return int(time.Until(expire).Milliseconds()) })
+}
+
+func expiretime(p []resp.Payload) resp.Payload {
+	return keyTTL(p, func(expire time.Time) int { return int(expire.Unix()) })
+}
+
+func pexpiretime(p []resp.Payload) resp.Payload {
+	return keyTTL(p, func(expire time.Time) int { return int(expire.UnixMilli()) })
+}
+
+// PERSIST key
+func persist(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	obj := lookupKey(string(p[0].Bulk))
+	if obj == nil || obj.expire.IsZero() {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	obj.expire = time.Time{}
+	dirty++
+	return resp.Payload{DataType: string(resp.INTEGER), Num: 1}
+}
+
+// rewriteExpire converts EXPIRE, PEXPIRE and EXPIREAT to PEXPIREAT with an
+// absolute time in milliseconds
+func rewriteExpire(argv []resp.Payload) []resp.Payload {
+	if len(argv) < 3 {
+		return argv
+	}
+	unit, absolute := time.Second, false
+	switch strings.ToUpper(string(argv[0].Bulk)) {
+	case "PEXPIRE":
+		unit = time.Millisecond
+	case "EXPIREAT":
+		absolute = true
+	}
+	ms, errPayload := parseKeyExpireTime(string(argv[2].Bulk), unit, absolute, time.Now())
+	if errPayload.DataType != "" {
+		return argv
+	}
+	rewritten := append([]resp.Payload{}, argv...)
+	rewritten[0] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte("PEXPIREAT")}
+	rewritten[2] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(strconv.FormatInt(ms, 10))}
+	return rewritten
+}
+
+// rewriteSet converts the EX, PX and EXAT options of SET to PXAT
+func rewriteSet(argv []resp.Payload) []resp.Payload {
+	for i := 3; i+1 < len(argv); i++ {
+		unit, absolute := time.Second, false
+		switch strings.ToUpper(string(argv[i].Bulk)) {
+		case "EX":
+		case "PX":
+			unit = time.Millisecond
+		case "EXAT":
+			absolute = true
+		default:
+			continue
+		}
+		// Invalid times are left to SET to report
+		if v, err := strconv.ParseInt(string(argv[i+1].Bulk), 10, 64); err != nil || v <= 0 {
+			return argv
+		}
+		ms, ok := parseExpireTime(string(argv[i+1].Bulk), unit, absolute, time.Now())
+		if !ok {
+			return argv
+		}
+		rewritten := append([]resp.Payload{}, argv...)
+		rewritten[i] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte("PXAT")}
+		rewritten[i+1] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(strconv.FormatInt(ms, 10))}
+		return rewritten
+	}
+	return argv
+}
+
+// Active expiration, modelled on the slow cycle of Redis. Keys with a time to
+// live are sampled and the expired ones removed, the sampling is repeated
+// while more than 25% of the sampled keys were expired and the cycle uses at
+// most 25% of the time between two runs of the server cron.
+
+const (
+	activeExpireCycleKeysPerLoop  = 20
+	activeExpireCycleStalePerc    = 25
+	activeExpireCycleSlowTimePerc = 25
+)
+
+// activeExpireKeys removes the expired keys among a sample of volatileKeys.
+// Ranging over a map starts at a random position, which gives a cheap random
+// sample.
+func activeExpireKeys(now time.Time) (sampled, expired int) {
+	for key := range volatileKeys {
+		if sampled == activeExpireCycleKeysPerLoop {
+			break
+		}
+		obj, ok := keyspace[key]
+		if !ok || obj.expire.IsZero() {
+			delete(volatileKeys, key)
+			continue
+		}
+		sampled++
+		if obj.isExpired(now) {
+			delete(keyspace, key)
+			delete(volatileKeys, key)
+			stats.expiredKeys++
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// activeExpireHashFields removes the expired fields among a sample of
+// volatileHashes, a hash counts as expired when at least one field was removed
+func activeExpireHashFields(now time.Time) (sampled, expired int) {
+	for key := range volatileHashes {
+		if sampled == activeExpireCycleKeysPerLoop {
+			break
+		}
+		obj, ok := keyspace[key]
+		if !ok || obj.kind != hashType || obj.value.(*redisHash).nextExpire.IsZero() {
+			delete(volatileHashes, key)
+			continue
+		}
+		sampled++
+		h := obj.value.(*redisHash)
+		if n := h.expireFields(now); n > 0 {
+			stats.expiredSubkeys += n
+			expired++
+			if h.len() == 0 {
+				delete(keyspace, key)
+				delete(volatileHashes, key)
+			}
+		}
+	}
+	return sampled, expired
+}
+
+func activeExpireCycle(hz int) {
+	start := time.Now()
+	limit := time.Second * activeExpireCycleSlowTimePerc / 100 / time.Duration(hz)
+	var totalSampled, totalExpired int
+	for {
+		now := time.Now()
+		sampled, expired := activeExpireKeys(now)
+		s, e := activeExpireHashFields(now)
+		sampled, expired = sampled+s, expired+e
+		totalSampled += sampled
+		totalExpired += expired
+		if sampled == 0 || expired*100 <= sampled*activeExpireCycleStalePerc {
+			break
+		}
+		if time.Since(start) > limit {
+			stats.expiredTimeCapReached++
+			break
+		}
+	}
+
+	// Running average of the keys found expired
+	var perc float64
+	if totalSampled > 0 {
+		perc = float64(totalExpired) / float64(totalSampled)
+	}
+	stats.expiredStalePerc = perc*0.05 + stats.expiredStalePerc*0.95
+	stats.expireCycleTime += time.Since(start)
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func TestExpire(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	set(args("key", "value"))
+	rpush(args("list", "a"))
+
+	if response := ttl(args("missing")); response.Num != -2 {
+		t.Errorf("Expected -2, got %d", response.Num)
+	}
+	if response := ttl(args("key")); response.Num != -1 {
+		t.Errorf("Expected -1, got %d", response.Num)
+	}
+	if response := expire(args("missing", "10")); response.Num != 0 {
+		t.Errorf("Expected 0, got %d", response.Num)
+	}
+
+	// Works whatever the type of the value
+	for _, key := range []string{"key", "list"} {
+		if response := expire(args(key, "100")); response.Num != 1 {
+			t.Errorf("Expected 1, got %d", response.Num)
+		}
+		if response := ttl(args(key)); response.Num != 100 {
+			t.Errorf("Expected 100, got %d", response.Num)
+		}
+		if response := pttl(args(key)); response.Num <= 99000 || response.Num > 100000 {
+			t.Errorf("Expected about 100000, got %d", response.Num)
+		}
+	}
+
+	if response := persist(args("key")); response.Num != 1 {
+		t.Errorf("Expected 1, got %d", response.Num)
+	}
+	if response := persist(args("key")); response.Num != 0 {
+		t.Errorf("Expected 0, got %d", response.Num)
+	}
+	if response := ttl(args("key")); response.Num != -1 {
+		t.Errorf("Expected -1, got %d", response.Num)
+	}
+
+	at := time.Now().Add(time.Hour)
+	pexpireat(args("key", strconv.FormatInt(at.UnixMilli(), 10)))
+	if response := pexpiretime(args("key")); response.Num != int(at.UnixMilli()) {
+		t.Errorf("Expected %d, got %d", at.UnixMilli(), response.Num)
+	}
+	if response := expiretime(args("key")); response.Num != int(at.Unix()) {
+		t.Errorf("Expected %d, got %d", at.Unix(), response.Num)
+	}
+
+	// A time in the past deletes the key
+	if response := expire(args("list", "-1")); response.Num != 1 {
+		t.Errorf("Expected 1, got %d", response.Num)
+	}
+	if response := exist(args("list")); response.Num != 0 {
+		t.Errorf("Expected list to be deleted")
+	}
+
+	if response := expire(args("key", "abc")); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+	if response := pexpire(args("key", "9223372036854775807")); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+}
+
+func TestExpireConditions(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	set(args("key", "value"))
+
+	tests := []struct {
+		args     []string
+		expected int
+		ttl      int
+	}{
+		{[]string{"key", "100", "XX"}, 0, -1},
+		{[]string{"key", "100", "GT"}, 0, -1},
+		{[]string{"key", "100", "NX"}, 1, 100},
+		{[]string{"key", "200", "NX"}, 0, 100},
+		{[]string{"key", "50", "GT"}, 0, 100},
+		{[]string{"key", "200", "GT"}, 1, 200},
+		{[]string{"key", "300", "LT"}, 0, 200},
+		{[]string{"key", "150", "xx", "lt"}, 1, 150},
+	}
+	for _, test := range tests {
+		if response := expire(args(test.args...)); response.Num != test.expected {
+			t.Errorf("%v: expected %d, got %d", test.args, test.expected, response.Num)
+		}
+		if response := ttl(args("key")); response.Num != test.ttl {
+			t.Errorf("%v: expected TTL %d, got %d", test.args, test.ttl, response.Num)
+		}
+	}
+
+	persist(args("key"))
+	if response := expire(args("key", "100", "LT")); response.Num != 1 {
+		t.Errorf("Expected LT to set a TTL on a persistent key, got %d", response.Num)
+	}
+
+	for _, invalid := range [][]string{
+		{"key", "10", "NX", "XX"},
+		{"key", "10", "GT", "LT"},
+		{"key", "10", "NX", "GT"},
+		{"key", "10", "FOO"},
+	} {
+		if response := expire(args(invalid...)); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected error for %v, got %v", invalid, response)
+		}
+	}
+}
+
+func TestExpireAof(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "key1", "v1", "EX", "100"), aof)
+	processRequest(nil, request("SET", "key2", "v2"), aof)
+	processRequest(nil, request("EXPIRE", "key2", "200"), aof)
+	processRequest(nil, request("SET", "key3", "v3", "PX", "100000"), aof)
+	processRequest(nil, request("PERSIST", "key3"), aof)
+	expected := map[string]int{}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		expected[key] = pexpiretime(args(key)).Num
+	}
+
+	content, _ := os.ReadFile(aof.file.Name())
+	if strings.Contains(string(content), "EXPIRE\r") || strings.Contains(string(content), "EX\r") {
+		t.Errorf("Expected relative expirations to be logged as absolute times, got %q", content)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	keyspace = map[string]*redisObject{}
+	aof.load()
+	for key, value := range expected {
+		if got := pexpiretime(args(key)).Num; got != value {
+			t.Errorf("Expected expire time %d for %s after replay, got %d", value, key, got)
+		}
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	volatileKeys = map[string]struct{}{}
+	volatileHashes = map[string]struct{}{}
+	expiredKeys, expiredSubkeys := stats.expiredKeys, stats.expiredSubkeys
+
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < 1000; i++ {
+		setKey("expired"+strconv.Itoa(i), &redisObject{kind: stringType, value: "v", expire: past})
+	}
+	for i := 0; i < 10; i++ {
+		setKey("volatile"+strconv.Itoa(i), &redisObject{kind: stringType, value: "v", expire: time.Now().Add(time.Hour)})
+	}
+	set(args("persistent", "v"))
+	hset(args("hash", "f1", "v1", "f2", "v2"))
+	hpexpire(args("hash", "1", "FIELDS", "1", "f1"))
+	time.Sleep(5 * time.Millisecond)
+
+	// The cycle goes on while more than 25% of the sample is expired, so
+	// only a few expired keys may be left
+	activeExpireCycle(10)
+	if remaining := len(keyspace) - 12; remaining > 50 {
+		t.Errorf("Expected most expired keys to be removed, %d are left", remaining)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := keyspace["volatile"+strconv.Itoa(i)]; !ok {
+			t.Fatalf("Expected keys with a time to live in the future to be kept")
+		}
+	}
+	if _, ok := keyspace["persistent"]; !ok {
+		t.Errorf("Expected keys without time to live to be kept")
+	}
+	if stats.expiredKeys-expiredKeys < 950 {
+		t.Errorf("Expected expired_keys to be incremented, got %d", stats.expiredKeys-expiredKeys)
+	}
+	if stats.expiredSubkeys-expiredSubkeys != 1 {
+		t.Errorf("Expected one expired hash field, got %d", stats.expiredSubkeys-expiredSubkeys)
+	}
+	if _, ok := keyspace["hash"].value.(*redisHash).fields["f1"]; ok {
+		t.Errorf("Expected hash field to be removed")
+	}
+}
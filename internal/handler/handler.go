@@ -1,105 +1,329 @@
 package handler
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/ger/redis-lite-go/internal/resp"
 )
 
-var handlers = map[string]func([]resp.Payload) resp.Payload{
-	"PING":    ping,
-	"COMMAND": command,
-	"ECHO":    echo,
-	"SET":     set,
-	"GET":     get,
-	"EXISTS":  exist,
-	"DEL":     del,
-	"INCR":    incr,
-	"HSET":    hset,
-	"HGET":    hget,
+// A command of the command table. Write commands modify the dataset, they are
+// appended to the AOF when they changed it.
+type redisCommand struct {
+	handler func([]resp.Payload) resp.Payload
+	write   bool
 }
 
-type stringValue struct {
-	value  string
-	expire time.Time
+const (
+	readonly = false
+	write    = true
+)
+
+var commands = map[string]redisCommand{
+	"PING":    {ping, readonly},
+	"COMMAND": {command, readonly},
+	"ECHO":    {echo, readonly},
+	"SET":     {set, write},
+	"GET":     {get, readonly},
+	"EXISTS":  {exist, readonly},
+	"DEL":     {del, write},
+	"INCR":    {incr, write},
+	"TYPE":    {typeCmd, readonly},
+
+	"SAVE":     {saveCmd, readonly},
+	"BGSAVE":   {bgsave, readonly},
+	"LASTSAVE": {lastsave, readonly},
+
+	"EXPIRE":      {expire, write},
+	"PEXPIRE":     {pexpire, write},
+	"EXPIREAT":    {expireat, write},
+	"PEXPIREAT":   {pexpireat, write},
+	"TTL":         {ttl, readonly},
+	"PTTL":        {pttl, readonly},
+	"EXPIRETIME":  {expiretime, readonly},
+	"PEXPIRETIME": {pexpiretime, readonly},
+	"PERSIST":     {persist, write},
+
+	"HSET":         {hset, write},
+	"HMSET":        {hmset, write},
+	"HSETNX":       {hsetnx, write},
+	"HGET":         {hget, readonly},
+	"HMGET":        {hmget, readonly},
+	"HGETALL":      {hgetall, readonly},
+	"HDEL":         {hdel, write},
+	"HLEN":         {hlen, readonly},
+	"HKEYS":        {hkeys, readonly},
+	"HVALS":        {hvals, readonly},
+	"HEXISTS":      {hexists, readonly},
+	"HINCRBY":      {hincrby, write},
+	"HINCRBYFLOAT": {hincrbyfloat, write},
+	"HSTRLEN":      {hstrlen, readonly},
+	"HRANDFIELD":   {hrandfield, readonly},
+	"HEXPIRE":      {hexpire, write},
+	"HPEXPIRE":     {hpexpire, write},
+	"HEXPIREAT":    {hexpireat, write},
+	"HPEXPIREAT":   {hpexpireat, write},
+	"HTTL":         {httl, readonly},
+	"HPTTL":        {hpttl, readonly},
+	"HEXPIRETIME":  {hexpiretime, readonly},
+	"HPEXPIRETIME": {hpexpiretime, readonly},
+	"HPERSIST":     {hpersist, write},
+
+	"LPUSH":     {lpush, write},
+	"RPUSH":     {rpush, write},
+	"LPUSHX":    {lpushx, write},
+	"RPUSHX":    {rpushx, write},
+	"LPOP":      {lpop, write},
+	"RPOP":      {rpop, write},
+	"LLEN":      {llen, readonly},
+	"LRANGE":    {lrange, readonly},
+	"LINDEX":    {lindex, readonly},
+	"LSET":      {lset, write},
+	"LREM":      {lrem, write},
+	"LTRIM":     {ltrim, write},
+	"LINSERT":   {linsert, write},
+	"LPOS":      {lpos, readonly},
+	"LMOVE":     {lmove, write},
+	"RPOPLPUSH": {rpoplpush, write},
+
+	"SADD":        {sadd, write},
+	"SREM":        {srem, write},
+	"SMEMBERS":    {smembers, readonly},
+	"SISMEMBER":   {sismember, readonly},
+	"SMISMEMBER":  {smismember, readonly},
+	"SCARD":       {scard, readonly},
+	"SMOVE":       {smove, write},
+	"SPOP":        {spop, write},
+	"SRANDMEMBER": {srandmember, readonly},
+	"SINTER":      {sinter, readonly},
+	"SUNION":      {sunion, readonly},
+	"SDIFF":       {sdiff, readonly},
+	"SINTERCARD":  {sintercard, readonly},
+	"SINTERSTORE": {sinterstore, write},
+	"SUNIONSTORE": {sunionstore, write},
+	"SDIFFSTORE":  {sdiffstore, write},
+
+	"ZADD":             {zadd, write},
+	"ZINCRBY":          {zincrby, write},
+	"ZREM":             {zrem, write},
+	"ZCARD":            {zcard, readonly},
+	"ZSCORE":           {zscore, readonly},
+	"ZRANK":            {zrank, readonly},
+	"ZREVRANK":         {zrevrank, readonly},
+	"ZCOUNT":           {zcount, readonly},
+	"ZLEXCOUNT":        {zlexcount, readonly},
+	"ZRANGE":           {zrange, readonly},
+	"ZREVRANGE":        {zrevrange, readonly},
+	"ZRANGEBYSCORE":    {zrangebyscore, readonly},
+	"ZREVRANGEBYSCORE": {zrevrangebyscore, readonly},
+	"ZRANGEBYLEX":      {zrangebylex, readonly},
+	"ZREVRANGEBYLEX":   {zrevrangebylex, readonly},
+	"ZPOPMIN":          {zpopmin, write},
+	"ZPOPMAX":          {zpopmax, write},
+	"ZUNIONSTORE":      {zunionstore, write},
+	"ZINTERSTORE":      {zinterstore, write},
+}
+
+// Commands working on the AOF itself or reporting on it
+var aofHandlers = map[string]func(*Aof, []resp.Payload) resp.Payload{
+	"BGREWRITEAOF": bgrewriteaof,
+	"CONFIG":       configCmd,
+	"INFO":         info,
+}
+
+// Commands rewritten before being logged and executed, so that replaying the
+// AOF gives the same result at any time
+var aofRewrites = map[string]func([]resp.Payload) []resp.Payload{
+	"HEXPIRE":   rewriteHexpire,
+	"HPEXPIRE":  rewriteHexpire,
+	"HEXPIREAT": rewriteHexpire,
+	"EXPIRE":    rewriteExpire,
+	"PEXPIRE":   rewriteExpire,
+	"EXPIREAT":  rewriteExpire,
+	"SET":       rewriteSet,
 }
 
-var stringMap = map[string]stringValue{}
-var hashMap = map[string]map[string]stringValue{}
+var missingArgumentsError = resp.NewError("ERR", "Missing arguments for command")
+var syntaxError = resp.NewError("ERR", "syntax error")
+var notIntegerError = resp.NewError("ERR", "value is not an integer or out of range")
 
-var stringMapLock sync.RWMutex
-var hashMapLock sync.RWMutex
+// bulkArray builds an array of bulk strings
+func bulkArray(values []string) resp.Payload {
+	array := make([]resp.Payload, len(values))
+	for i, v := range values {
+		array[i] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(v)}
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+// bulkMap builds a map from interleaved keys and values, sent as an array to
+// RESP2 clients
+func bulkMap(values []string) resp.Payload {
+	p := bulkArray(values)
+	p.DataType = string(resp.MAP)
+	return p
+}
+
+// bulkSet builds a set, sent as an array to RESP2 clients
+func bulkSet(values []string) resp.Payload {
+	p := bulkArray(values)
+	p.DataType = string(resp.SET)
+	return p
+}
 
 func updateInMemoryStore(request string, params []resp.Payload) resp.Payload {
-	var response resp.Payload
-	if _, ok := handlers[request]; ok {
-		response = handlers[request](params)
-	} else {
-		response.DataType = string(resp.ERROR)
-		response.Str = "Unknown command"
+	if cmd, ok := commands[request]; ok {
+		return cmd.handler(params)
 	}
-	return response
+	return unknownCommandError(request, params)
+}
+
+// unknownCommandError quotes the command and its first arguments the way
+// Redis does
+func unknownCommandError(request string, params []resp.Payload) resp.Payload {
+	var args strings.Builder
+	for _, param := range params {
+		if args.Len() > 128 {
+			break
+		}
+		args.WriteString("'" + string(param.Bulk) + "' ")
+	}
+	return resp.NewError("ERR", fmt.Sprintf("unknown command '%s', with args beginning with: %s", request, args.String()))
 }
 
-func processRequest(cmd *resp.Payload, aof *Aof) resp.Payload {
-	p := resp.Payload{}
+func processRequest(c *client, cmd *resp.Payload, aof *Aof) resp.Payload {
 	if cmd.DataType != string(resp.ARRAY) {
-		p.DataType = string(resp.ERROR)
-		p.Str = "Expected array of bulk strings"
-		return p
+		return resp.NewError("ERR", "Expected array of bulk strings")
 	}
 
 	if len(cmd.Array) == 0 {
-		p.DataType = string(resp.ERROR)
-		p.Str = "Null array command"
-		return p
+		return resp.NewError("ERR", "Null array command")
 	}
 
 	request, params := resp.ParseRequest(cmd)
-	if request == "SET" || request == "INCR" || request == "HSET" {
-		aof.Write(cmd)
+	if c != nil {
+		if handler, ok := clientHandlers[request]; ok {
+			return handler(c, params)
+		}
+		if !c.authenticated {
+			return noAuthError
+		}
+	}
+	if _, ok := blockingHandlers[request]; ok {
+		return processBlockingRequest(c, request, params, aof)
 	}
 
-	response := updateInMemoryStore(request, params)
+	// The command, its AOF append and the clients it unblocks are applied
+	// atomically, so the AOF is in the order the commands were applied
+	keyspaceLock.Lock()
+	defer keyspaceLock.Unlock()
+	if handler, ok := aofHandlers[request]; ok {
+		return handler(aof, params)
+	}
+	if rewrite, ok := aofRewrites[request]; ok {
+		cmd = &resp.Payload{DataType: string(resp.ARRAY), Array: rewrite(cmd.Array)}
+		request, params = resp.ParseRequest(cmd)
+	}
 
+	if commands[request].write {
+		if err := aof.writeErr(); err != nil {
+			return aofWriteError(err)
+		}
+	}
+
+	response := call(request, params, cmd, aof)
+	handleClientsBlockedOnKeys(aof)
+	return response
+}
+
+// call executes the command and appends it to the AOF once it is applied,
+// only when it modified the dataset. Failed commands and commands with no
+// effect are not logged. When the AOF write fails, the reply is the error
+// instead of the result of the command.
+func call(request string, params []resp.Payload, cmd *resp.Payload, aof *Aof) resp.Payload {
+	before := dirty
+	propagateAs = nil
+	response := updateInMemoryStore(request, params)
+	if dirty != before && commands[request].write {
+		cmds := []*resp.Payload{cmd}
+		if propagateAs != nil {
+			cmds = cmds[:0]
+			for i := range propagateAs {
+				cmds = append(cmds, &propagateAs[i])
+			}
+		}
+		if err := propagate(aof, cmds...); err != nil {
+			response = aofWriteError(err)
+		}
+	}
+	propagateAs = nil
 	return response
 }
 
+// propagate appends the commands to the AOF, then starts a rewrite when the
+// AOF grew enough. Those that failed to be written are written again before
+// anything else, see Aof.writeErr. keyspaceLock must be held.
+func propagate(aof *Aof, cmds ...*resp.Payload) error {
+	var first error
+	for _, cmd := range cmds {
+		if err := aof.Write(cmd); err != nil && first == nil {
+			log.Println("Error writing to the AOF file:", err)
+			first = err
+		}
+	}
+	if first == nil && aof.rewriteNeeded() {
+		aof.startRewrite()
+	}
+	return first
+}
+
+func aofWriteError(err error) resp.Payload {
+	return resp.NewError("MISCONF", "Errors writing to the AOF file: "+err.Error())
+}
+
+// Size of the pending replies above which they are flushed even though more
+// pipelined commands are buffered
+const replyFlushThreshold = 8 * 1024
+
 func HandleConnection(conn net.Conn, aof *Aof) {
 
 	defer conn.Close()
-	respReader := resp.NewRespReader(conn)
-	writer := resp.NewRespWriter(conn)
+	c := newClient(conn)
 
 	for {
 		// Parse payload that follows RESP protocol into payload struct
-		cmd, err := respReader.Read()
+		cmd, err := c.reader.ReadCommand()
 		var response resp.Payload
 
 		if err != nil {
 			if err != io.EOF {
+				// The rest of the stream can not be parsed reliably, the
+				// connection is closed after the error is sent
 				log.Println(err)
-				response.DataType = string(resp.ERROR)
-				response.Str = "Invalid request format"
-			} else {
-				return
+				response = resp.NewError("ERR", "Protocol error: "+err.Error())
+				c.writer.Write(&response)
 			}
+			return
 		}
 
 		// Array of Bulk strings is expected
 		if (cmd.DataType != string(resp.ARRAY)) || (len(cmd.Array) == 0) {
-			response.DataType = string(resp.ERROR)
-			response.Str = "Invalid request format"
+			response = resp.NewError("ERR", "Invalid request format")
 		} else {
-			response = processRequest(&cmd, aof)
+			response = processRequest(c, &cmd, aof)
 		}
 
-		err = writer.Write(&response)
+		err = c.writer.Append(&response)
+		// Replies of pipelined commands are sent together once every command
+		// received has been processed, or when enough of them are pending
+		if err == nil && (c.reader.Buffered() == 0 || c.writer.Buffered() >= replyFlushThreshold) {
+			err = c.writer.Flush()
+		}
 		if err != nil {
 			log.Println("writer : ", err)
 		}
@@ -115,163 +339,173 @@ func ping(p []resp.Payload) resp.Payload {
 
 func echo(p []resp.Payload) resp.Payload {
 	if len(p) != 1 {
-		return resp.Payload{DataType: string(resp.ERROR), Str: "Missing arguments for command"}
+		return resp.NewError("ERR", "Missing arguments for command")
 	}
 	return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: p[0].Bulk}
 }
 
 func command(p []resp.Payload) resp.Payload {
 	return resp.Payload{DataType: string(resp.ARRAY), Array: []resp.Payload{
-		{DataType: string(resp.BULKSTRING), Bulk: "ECHO"},
-		{DataType: string(resp.BULKSTRING), Bulk: "COMMAND"},
-		{DataType: string(resp.BULKSTRING), Bulk: "PING"},
-		{DataType: string(resp.BULKSTRING), Bulk: "HGET"},
-		{DataType: string(resp.BULKSTRING), Bulk: "HSET"},
-		{DataType: string(resp.BULKSTRING), Bulk: "HGETALL"},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("ECHO")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("COMMAND")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("PING")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("HGET")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("HSET")},
+		{DataType: string(resp.BULKSTRING), Bulk: []byte("HGETALL")},
 	}}
 }
 
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func set(p []resp.Payload) resp.Payload {
-
-	key := p[0].Bulk
-	value := p[1].Bulk
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	value := string(p[1].Bulk)
 	var expire time.Time
-
-	// Only handling EX options
-	if len(p) >= 4 {
-		ex_cmd := p[2].Bulk
-		ex_val := p[3].Bulk
-		if ex_cmd == "EX" {
-			// Set expiration time
-			expireInSecs, err := strconv.Atoi(ex_val)
-			if err == nil {
-				expire = time.Now().Add(time.Duration(expireInSecs) * time.Second)
+	var nx, xx, withGet, keepTTL, withExpire bool
+
+	now := time.Now()
+	for i := 2; i < len(p); i++ {
+		option := strings.ToUpper(string(p[i].Bulk))
+		switch option {
+		case "NX":
+			if xx {
+				return syntaxError
 			}
+			nx = true
+		case "XX":
+			if nx {
+				return syntaxError
+			}
+			xx = true
+		case "GET":
+			withGet = true
+		case "KEEPTTL":
+			if withExpire {
+				return syntaxError
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if keepTTL || withExpire || i+1 >= len(p) {
+				return syntaxError
+			}
+			v, err := strconv.ParseInt(string(p[i+1].Bulk), 10, 64)
+			if err != nil {
+				return notIntegerError
+			}
+			unit := time.Second
+			if option[0] == 'P' {
+				unit = time.Millisecond
+			}
+			ms, ok := parseExpireTime(string(p[i+1].Bulk), unit, strings.HasSuffix(option, "AT"), now)
+			if v <= 0 || !ok {
+				return resp.NewError("ERR", "invalid expire time in 'set' command")
+			}
+			expire = time.UnixMilli(ms)
+			withExpire = true
+			i++
+		default:
+			return syntaxError
 		}
 	}
-	stringMapLock.Lock()
-	defer stringMapLock.Unlock()
-	stringMap[key] = stringValue{value, expire}
+
+	obj := lookupKey(key)
+	old := resp.NullBulk
+	if withGet && obj != nil {
+		if obj.kind != stringType {
+			return wrongTypeError
+		}
+		old = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(obj.value.(string))}
+	}
+	if (nx && obj != nil) || (xx && obj == nil) {
+		return old
+	}
+	if keepTTL && obj != nil {
+		expire = obj.expire
+	}
+	setKey(key, &redisObject{kind: stringType, value: value, expire: expire})
+	if withGet {
+		return old
+	}
 	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
 }
 
 func get(p []resp.Payload) resp.Payload {
-	key := p[0].Bulk
-	stringMapLock.RLock()
-	defer stringMapLock.RUnlock()
-	if _, ok := stringMap[key]; ok {
-		if stringMap[key].expire.IsZero() {
-			return resp.Payload{DataType: string(resp.STRING), Str: stringMap[key].value}
-		}
-		if stringMap[key].expire.Before(time.Now()) {
-			delete(stringMap, key)
-			return resp.NilValue
-		}
-
-		return resp.Payload{DataType: string(resp.STRING), Str: stringMap[key].value}
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	obj, ok := lookupTyped(key, stringType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.NullBulk
 	}
-	return resp.NilValue
+	return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(obj.value.(string))}
 }
 
 func exist(p []resp.Payload) resp.Payload {
-	stringMapLock.RLock()
-	defer stringMapLock.RUnlock()
-
 	var count int
 
 	for i := 0; i < len(p); i++ {
-		key := p[i].Bulk
-		if _, ok := stringMap[key]; ok {
-			if stringMap[key].expire.IsZero() {
-				count++
-			} else if stringMap[key].expire.After(time.Now()) {
-				count++
-			}
+		if lookupKey(string(p[i].Bulk)) != nil {
+			count++
 		}
 	}
 	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
 }
 
 func del(p []resp.Payload) resp.Payload {
-	stringMapLock.Lock()
-	defer stringMapLock.Unlock()
-
 	var count int
 
 	for i := 0; i < len(p); i++ {
-		key := p[i].Bulk
-		if _, ok := stringMap[key]; ok {
-			delete(stringMap, key)
+		if deleteKey(string(p[i].Bulk)) {
 			count++
 		}
 	}
 	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
 }
 
-func incr(p []resp.Payload) resp.Payload {
-	stringMapLock.Lock()
-	defer stringMapLock.Unlock()
+func typeCmd(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return resp.NewError("ERR", "Missing arguments for command")
+	}
+	obj := lookupKey(string(p[0].Bulk))
+	if obj == nil {
+		return resp.Payload{DataType: string(resp.STRING), Str: "none"}
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: obj.kind.String()}
+}
 
+func incr(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
 	var count int
-	var strValue string
 
-	key := p[0].Bulk
-	if _, ok := stringMap[key]; ok {
-		if stringMap[key].expire.IsZero() {
-			strValue = stringMap[key].value
-		}
-		if stringMap[key].expire.Before(time.Now()) {
-			delete(stringMap, key)
-		} else {
-			strValue = stringMap[key].value
-		}
+	key := string(p[0].Bulk)
+	obj, ok := lookupTyped(key, stringType)
+	if !ok {
+		return wrongTypeError
 	}
-	if strValue != "" {
-		countOn64, err := strconv.ParseInt(strValue, 10, 64)
+	if obj != nil {
+		countOn64, err := strconv.ParseInt(obj.value.(string), 10, 64)
 		if err != nil {
-			return resp.Payload{DataType: string(resp.ERROR), Str: "Key value is not integer"}
+			return resp.NewError("ERR", "Key value is not integer")
 		}
 		count = int(countOn64)
 	}
 	count++
 	countStrValue := strconv.Itoa(count)
-	stringMap[key] = stringValue{value: countStrValue}
-	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
-}
-
-func hset(p []resp.Payload) resp.Payload {
-	var count int
-	hashKey := p[0].Bulk
-
-	hashMapLock.Lock()
-	defer hashMapLock.Unlock()
-	for i := 1; i < len(p); i += 2 {
-		key := p[i].Bulk
-		var expire time.Time
-		value := stringValue{p[i+1].Bulk, expire}
-		if _, ok := hashMap[hashKey]; !ok {
-			hashMap[hashKey] = map[string]stringValue{}
-		}
-		hashMap[hashKey][key] = value
-		count++
+	if obj != nil {
+		// INCR keeps the time to live of the key
+		obj.value = countStrValue
+		dirty++
+	} else {
+		setKey(key, &redisObject{kind: stringType, value: countStrValue})
 	}
 	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
 }
-
-func hget(p []resp.Payload) resp.Payload {
-
-	if len(p) < 2 {
-		return resp.Payload{DataType: string(resp.ERROR), Str: "Missing arguments for command"}
-	}
-	hashKey := p[0].Bulk
-	mapKey := p[1].Bulk
-
-	hashMapLock.RLock()
-	defer hashMapLock.RUnlock()
-	if _, ok := hashMap[hashKey]; ok {
-		if _, ok := hashMap[hashKey][mapKey]; ok {
-			return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: hashMap[hashKey][mapKey].value}
-		}
-	}
-	return resp.NilValue
-}
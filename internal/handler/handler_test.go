@@ -1,6 +1,11 @@
 package handler
 
 import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,8 +21,8 @@ func TestPing(t *testing.T) {
 
 	// Test with a parameter
 	testStr := "hello"
-	response = ping([]resp.Payload{{DataType: string(resp.BULKSTRING), Bulk: testStr}})
-	if response.DataType != string(resp.BULKSTRING) || response.Bulk != testStr {
+	response = ping([]resp.Payload{{DataType: string(resp.BULKSTRING), Bulk: []byte(testStr)}})
+	if response.DataType != string(resp.BULKSTRING) || string(response.Bulk) != testStr {
 		t.Errorf("Expected %s, got %s", testStr, response.Str)
 	}
 }
@@ -25,8 +30,8 @@ func TestPing(t *testing.T) {
 func TestEcho(t *testing.T) {
 	// Test with valid parameter
 	testStr := "hello"
-	response := echo([]resp.Payload{{DataType: string(resp.BULKSTRING), Bulk: testStr}})
-	if response.DataType != string(resp.BULKSTRING) || response.Bulk != testStr {
+	response := echo([]resp.Payload{{DataType: string(resp.BULKSTRING), Bulk: []byte(testStr)}})
+	if response.DataType != string(resp.BULKSTRING) || string(response.Bulk) != testStr {
 		t.Errorf("Expected %s, got %s", testStr, response.Str)
 	}
 
@@ -37,7 +42,7 @@ func TestEcho(t *testing.T) {
 	}
 
 	// Test with multiple parameters
-	response = echo([]resp.Payload{{Bulk: "one"}, {Bulk: "two"}})
+	response = echo([]resp.Payload{{Bulk: []byte("one")}, {Bulk: []byte("two")}})
 	if response.DataType != string(resp.ERROR) {
 		t.Errorf("Expected error, got %s", response.DataType)
 	}
@@ -52,59 +57,351 @@ func TestCommand(t *testing.T) {
 
 func TestSet(t *testing.T) {
 	// Test setting a key-value pair
-	response := set([]resp.Payload{{Bulk: "key1"}, {Bulk: "value1"}})
+	response := set([]resp.Payload{{Bulk: []byte("key1")}, {Bulk: []byte("value1")}})
 	if response.DataType != string(resp.STRING) || response.Str != "OK" {
 		t.Errorf("Expected OK, got %s", response.Str)
 	}
 
 	// Test setting a key-value pair with expiration
-	response = set([]resp.Payload{{Bulk: "key2"}, {Bulk: "value2"}, {Bulk: "EX"}, {Bulk: "10"}})
+	response = set([]resp.Payload{{Bulk: []byte("key2")}, {Bulk: []byte("value2")}, {Bulk: []byte("EX")}, {Bulk: []byte("10")}})
 	if response.DataType != string(resp.STRING) || response.Str != "OK" {
 		t.Errorf("Expected OK, got %s", response.Str)
 	}
 
 	// Test with invalid expiration time format
-	response = set([]resp.Payload{{Bulk: "key3"}, {Bulk: "value3"}, {Bulk: "EX"}, {Bulk: "invalid"}})
-	if response.DataType != string(resp.STRING) || response.Str != "OK" {
-		t.Errorf("Expected OK, got %s", response.Str)
+	response = set([]resp.Payload{{Bulk: []byte("key3")}, {Bulk: []byte("value3")}, {Bulk: []byte("EX")}, {Bulk: []byte("invalid")}})
+	if response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %s", response.Str)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	del(args("key"))
+
+	// NX and XX conditions
+	if response := set(args("key", "v1", "XX")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+	if response := set(args("key", "v1", "NX")); response.Str != "OK" {
+		t.Errorf("Expected OK, got %v", response)
+	}
+	if response := set(args("key", "v2", "NX")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+
+	// GET returns the previous value, even when NX prevents the update
+	if response := set(args("key", "v2", "GET", "nx")); string(response.Bulk) != "v1" {
+		t.Errorf("Expected v1, got %v", response)
+	}
+	if response := set(args("key", "v3", "GET", "PX", "100000")); string(response.Bulk) != "v1" {
+		t.Errorf("Expected v1, got %v", response)
+	}
+
+	// KEEPTTL retains the time to live of the previous value
+	expire := keyspace["key"].expire
+	set(args("key", "v4", "KEEPTTL"))
+	if keyspace["key"].expire != expire || keyspace["key"].value != "v4" {
+		t.Errorf("Expected TTL to be kept")
+	}
+	set(args("key", "v5"))
+	if !keyspace["key"].expire.IsZero() {
+		t.Errorf("Expected TTL to be cleared")
+	}
+
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	set(args("key", "v6", "EXAT", strconv.FormatInt(at.Unix(), 10)))
+	if !keyspace["key"].expire.Equal(at) {
+		t.Errorf("Expected expire at %v, got %v", at, keyspace["key"].expire)
+	}
+	set(args("key", "v7", "PXAT", "1"))
+	if response := get(args("key")); !response.Null {
+		t.Errorf("Expected key to be expired, got %v", response)
+	}
+
+	invalid := [][]string{
+		{"key", "v", "NX", "XX"},
+		{"key", "v", "EX", "10", "KEEPTTL"},
+		{"key", "v", "KEEPTTL", "PX", "10"},
+		{"key", "v", "EX", "10", "PX", "10"},
+		{"key", "v", "EX"},
+		{"key", "v", "EX", "0"},
+		{"key", "v", "EX", "-5"},
+		{"key", "v", "UNKNOWN"},
+	}
+	for _, a := range invalid {
+		if response := set(args(a...)); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected error for %v, got %v", a, response)
+		}
+	}
+
+	hset(args("hash", "f", "v"))
+	if response := set(args("hash", "v", "GET")); response.Str != wrongTypeError.Str {
+		t.Errorf("Expected WRONGTYPE, got %v", response)
 	}
 }
 
 func TestGet(t *testing.T) {
 	// Setting up test data
-	stringMap["key1"] = stringValue{"value1", time.Time{}}
-	stringMap["key2"] = stringValue{"value2", time.Now().Add(time.Second)}
+	keyspace["key1"] = &redisObject{kind: stringType, value: "value1"}
+	keyspace["key2"] = &redisObject{kind: stringType, value: "value2", expire: time.Now().Add(time.Second)}
 
 	// Test getting an existing key
-	response := get([]resp.Payload{{Bulk: "key1"}})
-	if response.DataType != string(resp.STRING) || response.Str != "value1" {
-		t.Errorf("Expected value1, got %s", response.Str)
+	response := get([]resp.Payload{{Bulk: []byte("key1")}})
+	if response.DataType != string(resp.BULKSTRING) || string(response.Bulk) != "value1" {
+		t.Errorf("Expected value1, got %s", response.Bulk)
 	}
 
 	// Test getting a non-existing key
-	response = get([]resp.Payload{{Bulk: "nonexisting"}})
-	if response.Bulk != resp.NilValue.Bulk {
-		t.Errorf("Expected -1, got %s", response.Bulk)
+	response = get([]resp.Payload{{Bulk: []byte("nonexisting")}})
+	if !response.Null {
+		t.Errorf("Expected nil, got %v", response)
 	}
 
 	// Test getting an expired key
 	time.Sleep(time.Second * 2)
-	response = get([]resp.Payload{{Bulk: "key2"}})
-	if response.Bulk != resp.NilValue.Bulk {
-		t.Errorf("Expected -1, got %s", response.Bulk)
+	response = get([]resp.Payload{{Bulk: []byte("key2")}})
+	if !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+}
+
+func TestArity(t *testing.T) {
+	for name, handler := range map[string]func([]resp.Payload) resp.Payload{"GET": get, "INCR": incr, "TYPE": typeCmd} {
+		if response := handler(nil); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected an error for %s without arguments, got %v", name, response)
+		}
 	}
 }
 
 func TestExist(t *testing.T) {
 	// Setting up test data
-	stringMap["key1"] = stringValue{"value1", time.Time{}}
-	stringMap["key2"] = stringValue{"value2", time.Now().Add(time.Second)}
+	keyspace["key1"] = &redisObject{kind: stringType, value: "value1"}
+	keyspace["key2"] = &redisObject{kind: stringType, value: "value2", expire: time.Now().Add(time.Second)}
 
 	// Test with existing keys
-	response := exist([]resp.Payload{{Bulk: "key1"}, {Bulk: "key2"}})
+	response := exist([]resp.Payload{{Bulk: []byte("key1")}, {Bulk: []byte("key2")}})
 	if response.DataType != string(resp.INTEGER) || response.Num != 2 {
 		t.Errorf("Expected 2, got %d", response.Num)
 	}
 
 	// Test with non-existing keys
 }
+
+func TestDel(t *testing.T) {
+	keyspace["str"] = &redisObject{kind: stringType, value: "value"}
+	hset([]resp.Payload{{Bulk: []byte("hash")}, {Bulk: []byte("field")}, {Bulk: []byte("value")}})
+
+	response := del([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("hash")}, {Bulk: []byte("nonexisting")}})
+	if response.DataType != string(resp.INTEGER) || response.Num != 2 {
+		t.Errorf("Expected 2, got %d", response.Num)
+	}
+
+	response = exist([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("hash")}})
+	if response.Num != 0 {
+		t.Errorf("Expected 0, got %d", response.Num)
+	}
+}
+
+func TestType(t *testing.T) {
+	set([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("value")}})
+	hset([]resp.Payload{{Bulk: []byte("hash")}, {Bulk: []byte("field")}, {Bulk: []byte("value")}})
+
+	tests := map[string]string{"str": "string", "hash": "hash", "nonexisting": "none"}
+	for key, expected := range tests {
+		response := typeCmd([]resp.Payload{{Bulk: []byte(key)}})
+		if response.DataType != string(resp.STRING) || response.Str != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, key, response.Str)
+		}
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	set([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("value")}})
+	hset([]resp.Payload{{Bulk: []byte("hash")}, {Bulk: []byte("field")}, {Bulk: []byte("value")}})
+
+	responses := []resp.Payload{
+		get([]resp.Payload{{Bulk: []byte("hash")}}),
+		incr([]resp.Payload{{Bulk: []byte("hash")}}),
+		hget([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("field")}}),
+		hset([]resp.Payload{{Bulk: []byte("str")}, {Bulk: []byte("field")}, {Bulk: []byte("value")}}),
+	}
+	for _, response := range responses {
+		if response.DataType != string(resp.ERROR) || response.Str != wrongTypeError.Str {
+			t.Errorf("Expected WRONGTYPE error, got %v", response)
+		}
+	}
+
+	// SET overwrites whatever type the key holds
+	set([]resp.Payload{{Bulk: []byte("hash")}, {Bulk: []byte("value")}})
+	response := typeCmd([]resp.Payload{{Bulk: []byte("hash")}})
+	if response.Str != "string" {
+		t.Errorf("Expected string, got %s", response.Str)
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	server, conn := net.Pipe()
+	go HandleConnection(server, aof)
+	defer conn.Close()
+	reader := resp.NewRespReader(conn)
+
+	value := []byte("line1\r\nline2\n\x00\xff$3\r\n")
+	setCmd := request("SET", "key", "")
+	setCmd.Array[2].Bulk = value
+	conn.Write(setCmd.Write())
+	if response, err := reader.Read(); err != nil || response.Str != "OK" {
+		t.Fatalf("Expected OK, got %v %v", response, err)
+	}
+	conn.Write(request("GET", "key").Write())
+	if response, err := reader.Read(); err != nil || !bytes.Equal(response.Bulk, value) {
+		t.Errorf("Expected %q, got %q %v", value, response.Bulk, err)
+	}
+}
+
+func TestBulkLengthLimit(t *testing.T) {
+	aof := newTestAof(t)
+	serverConfig.Set("proto-max-bulk-len", "1mb")
+	defer serverConfig.Set("proto-max-bulk-len", "512mb")
+
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(server, aof)
+		close(done)
+	}()
+	defer conn.Close()
+
+	// The error is sent and the connection closed without reading the value
+	conn.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$2000000\r\n"))
+	response, err := resp.NewRespReader(conn).Read()
+	if err != nil || response.DataType != string(resp.ERROR) || !strings.Contains(response.Str, "Protocol error") {
+		t.Errorf("Expected protocol error, got %v %v", response, err)
+	}
+	<-done
+}
+
+func TestNullAndErrorReplies(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	server, conn := net.Pipe()
+	go HandleConnection(server, newTestAof(t))
+	defer conn.Close()
+	set(args("str", "value"))
+
+	// Raw bytes, as parsed by the clients
+	tests := []struct {
+		cmd      []string
+		expected string
+	}{
+		{[]string{"GET", "missing"}, "$-1\r\n"},
+		{[]string{"LPOP", "missing", "2"}, "*-1\r\n"},
+		{[]string{"BLPOP", "missing", "0.01"}, "*-1\r\n"},
+		{[]string{"HMGET", "missing", "f"}, "*1\r\n$-1\r\n"},
+		{[]string{"LPUSH", "str", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"FOO", "a", "b"}, "-ERR unknown command 'FOO', with args beginning with: 'a' 'b' \r\n"},
+	}
+	buf := make([]byte, 256)
+	for _, tt := range tests {
+		conn.Write(request(tt.cmd...).Write())
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != tt.expected {
+			t.Errorf("%v: expected %q, got %q %v", tt.cmd, tt.expected, buf[:n], err)
+		}
+	}
+}
+
+func TestInlineCommands(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	server, conn := net.Pipe()
+	go HandleConnection(server, newTestAof(t))
+	defer conn.Close()
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"PING\r\n", "+PONG\r\n"},
+		{"set key \"hello world\"\n", "+OK\r\n"},
+		{"GET key\r\n", "$11\r\nhello world\r\n"},
+		{"SET key 'unbalanced\r\n", "-ERR Protocol error: unbalanced quotes in request\r\n"},
+	}
+	buf := make([]byte, 256)
+	for _, tt := range tests {
+		conn.Write([]byte(tt.line))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != tt.expected {
+			t.Errorf("%q: expected %q, got %q %v", tt.line, tt.expected, buf[:n], err)
+		}
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	server, conn := net.Pipe()
+	go HandleConnection(server, newTestAof(t))
+	defer conn.Close()
+
+	var pipeline []byte
+	pipeline = append(pipeline, request("SET", "key", "v").Write()...)
+	pipeline = append(pipeline, request("GET", "key").Write()...)
+	pipeline = append(pipeline, "PING\r\n"...)
+	go conn.Write(pipeline)
+
+	// The replies are flushed together once the three commands are processed
+	expected := "+OK\r\n$1\r\nv\r\n+PONG\r\n"
+	buf := make([]byte, 256)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != expected {
+		t.Errorf("Expected %q in a single write, got %q %v", expected, buf[:n], err)
+	}
+}
+
+// Throughput of SET and GET sent over TCP in batches of depth commands.
+// Without pipelining each reply is a write, with pipelining the replies of a
+// batch are sent in a few writes.
+func BenchmarkPipeline(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer ln.Close()
+	aof := newTestAof(b)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go HandleConnection(conn, aof)
+		}
+	}()
+
+	for _, depth := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			reader := resp.NewRespReader(conn)
+
+			var batch []byte
+			for i := 0; i < depth; i++ {
+				batch = append(batch, request("SET", "key"+strconv.Itoa(i), "value").Write()...)
+				batch = append(batch, request("GET", "key"+strconv.Itoa(i)).Write()...)
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n += 2 * depth {
+				if _, err := conn.Write(batch); err != nil {
+					b.Fatal(err)
+				}
+				for i := 0; i < 2*depth; i++ {
+					if _, err := reader.Read(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
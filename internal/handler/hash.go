@@ -0,0 +1,442 @@
+package handler
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Hash commands, values are stored as a map of fields
+
+// Hash fields, expire is set by HEXPIRE and friends
+type stringValue struct {
+	value  string
+	expire time.Time
+	// Index of the field in names
+	index int
+}
+
+type redisHash struct {
+	fields map[string]stringValue
+	// The field names, so that fields can be picked at random
+	names []string
+	// Earliest expiration among the fields, zero when no field has one
+	nextExpire time.Time
+}
+
+func newHash() *redisHash {
+	return &redisHash{fields: map[string]stringValue{}}
+}
+
+// expireFields removes the expired fields. The fields are only scanned once
+// the earliest expiration is reached.
+func (h *redisHash) expireFields(now time.Time) int {
+	if h.nextExpire.IsZero() || now.Before(h.nextExpire) {
+		return 0
+	}
+	var expired int
+	h.nextExpire = time.Time{}
+	for f, v := range h.fields {
+		if v.expire.IsZero() {
+			continue
+		}
+		if !v.expire.After(now) {
+			h.remove(f)
+			expired++
+		} else if h.nextExpire.IsZero() || v.expire.Before(h.nextExpire) {
+			h.nextExpire = v.expire
+		}
+	}
+	return expired
+}
+
+func (h *redisHash) get(field string) (string, bool) {
+	v, ok := h.fields[field]
+	return v.value, ok
+}
+
+// set stores the value and clears the time to live of the field. It returns
+// true when the field is new.
+func (h *redisHash) set(field, value string) bool {
+	v, exists := h.fields[field]
+	if !exists {
+		v.index = len(h.names)
+		h.names = append(h.names, field)
+	}
+	h.fields[field] = stringValue{value: value, index: v.index}
+	return !exists
+}
+
+// remove deletes the field, reporting whether it existed
+func (h *redisHash) remove(field string) bool {
+	v, ok := h.fields[field]
+	if !ok {
+		return false
+	}
+	// The last name takes the place of the removed one
+	last := h.names[len(h.names)-1]
+	h.names[v.index] = last
+	lv := h.fields[last]
+	lv.index = v.index
+	h.fields[last] = lv
+	h.names = h.names[:len(h.names)-1]
+	delete(h.fields, field)
+	return true
+}
+
+// setValue updates the value keeping the time to live of the field, as
+// HINCRBY does
+func (h *redisHash) setValue(field, value string) {
+	v := h.fields[field]
+	v.value = value
+	h.fields[field] = v
+}
+
+func (h *redisHash) setExpire(field string, expire time.Time) {
+	v := h.fields[field]
+	v.expire = expire
+	h.fields[field] = v
+	if !expire.IsZero() && (h.nextExpire.IsZero() || expire.Before(h.nextExpire)) {
+		h.nextExpire = expire
+	}
+}
+
+func (h *redisHash) len() int {
+	return len(h.fields)
+}
+
+// lookupHash returns the hash stored at key, or nil when it does not exist
+func lookupHash(key string) (*redisHash, bool) {
+	obj, ok := lookupTyped(key, hashType)
+	if !ok || obj == nil {
+		return nil, ok
+	}
+	return obj.value.(*redisHash), true
+}
+
+// lookupOrCreateHash returns the hash stored at key, creating it if needed
+func lookupOrCreateHash(key string) (*redisHash, bool) {
+	hash, ok := lookupHash(key)
+	if !ok {
+		return nil, false
+	}
+	if hash == nil {
+		hash = newHash()
+		setKey(key, &redisObject{kind: hashType, value: hash})
+	}
+	return hash, true
+}
+
+// HSET key field value [field value ...]
+// Returns the number of fields that were added
+func hset(p []resp.Payload) resp.Payload {
+	if len(p) < 3 || len(p)%2 == 0 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupOrCreateHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var count int
+	for i := 1; i < len(p); i += 2 {
+		if hash.set(string(p[i].Bulk), string(p[i+1].Bulk)) {
+			count++
+		}
+	}
+	dirty += (len(p) - 1) / 2
+	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
+}
+
+func hmset(p []resp.Payload) resp.Payload {
+	response := hset(p)
+	if response.DataType == string(resp.ERROR) {
+		return response
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+}
+
+func hsetnx(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupOrCreateHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if _, exists := hash.get(string(p[1].Bulk)); exists {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	hash.set(string(p[1].Bulk), string(p[2].Bulk))
+	dirty++
+	return resp.Payload{DataType: string(resp.INTEGER), Num: 1}
+}
+
+func hget(p []resp.Payload) resp.Payload {
+
+	if len(p) < 2 {
+		return resp.NewError("ERR", "Missing arguments for command")
+	}
+	hashKey := string(p[0].Bulk)
+	mapKey := string(p[1].Bulk)
+
+	hash, ok := lookupHash(hashKey)
+	if !ok {
+		return wrongTypeError
+	}
+	if hash != nil {
+		if value, ok := hash.get(mapKey); ok {
+			return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(value)}
+		}
+	}
+	return resp.NullBulk
+}
+
+func hmget(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	array := make([]resp.Payload, 0, len(p)-1)
+	for _, f := range p[1:] {
+		if hash == nil {
+			array = append(array, resp.NullBulk)
+		} else if value, ok := hash.get(string(f.Bulk)); ok {
+			array = append(array, resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(value)})
+		} else {
+			array = append(array, resp.NullBulk)
+		}
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+// hashContent returns the fields and/or the values of the hash
+func hashContent(p []resp.Payload, withFields, withValues bool) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	reply := bulkArray
+	if withFields && withValues {
+		reply = bulkMap
+	}
+	if hash == nil {
+		return reply(nil)
+	}
+	values := make([]string, 0, hash.len()*2)
+	for f, v := range hash.fields {
+		if withFields {
+			values = append(values, f)
+		}
+		if withValues {
+			values = append(values, v.value)
+		}
+	}
+	return reply(values)
+}
+
+func hgetall(p []resp.Payload) resp.Payload { return hashContent(p, true, true) }
+func hkeys(p []resp.Payload) resp.Payload   { return hashContent(p, true, false) }
+func hvals(p []resp.Payload) resp.Payload   { return hashContent(p, false, true) }
+
+// HDEL key field [field ...]
+// The hash is removed once its last field is deleted
+func hdel(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if hash == nil {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	var count int
+	for _, f := range p[1:] {
+		if hash.remove(string(f.Bulk)) {
+			count++
+		}
+	}
+	dirty += count
+	if hash.len() == 0 {
+		delete(keyspace, string(p[0].Bulk))
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
+}
+
+func hlen(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var length int
+	if hash != nil {
+		length = hash.len()
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: length}
+}
+
+func hexists(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var found int
+	if hash != nil {
+		if _, exists := hash.get(string(p[1].Bulk)); exists {
+			found = 1
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: found}
+}
+
+func hstrlen(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var length int
+	if hash != nil {
+		value, _ := hash.get(string(p[1].Bulk))
+		length = len(value)
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: length}
+}
+
+// HINCRBY key field increment
+func hincrby(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	incr, err := strconv.ParseInt(string(p[2].Bulk), 10, 64)
+	if err != nil {
+		return notIntegerError
+	}
+	hash, ok := lookupOrCreateHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var current int64
+	if value, exists := hash.get(string(p[1].Bulk)); exists {
+		current, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return resp.NewError("ERR", "hash value is not an integer")
+		}
+	}
+	if (incr < 0 && current < math.MinInt64-incr) || (incr > 0 && current > math.MaxInt64-incr) {
+		return resp.NewError("ERR", "increment or decrement would overflow")
+	}
+	current += incr
+	hash.setValue(string(p[1].Bulk), strconv.FormatInt(current, 10))
+	dirty++
+	return resp.Payload{DataType: string(resp.INTEGER), Num: int(current)}
+}
+
+// HINCRBYFLOAT key field increment
+func hincrbyfloat(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	incr, ok := parseScore(string(p[2].Bulk))
+	if !ok || math.IsInf(incr, 0) {
+		return notFloatError
+	}
+	hash, ok := lookupOrCreateHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var current float64
+	if value, exists := hash.get(string(p[1].Bulk)); exists {
+		current, ok = parseScore(value)
+		if !ok {
+			return resp.NewError("ERR", "hash value is not a float")
+		}
+	}
+	current += incr
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return resp.NewError("ERR", "increment would produce NaN or Infinity")
+	}
+	value := strconv.FormatFloat(current, 'f', -1, 64)
+	hash.setValue(string(p[1].Bulk), value)
+	dirty++
+	// The result depends on the float arithmetic, the value is logged instead,
+	// followed by the time to live of the field that HSET clears
+	alsoPropagate("HSET", string(p[0].Bulk), string(p[1].Bulk), value)
+	if expire := hash.fields[string(p[1].Bulk)].expire; !expire.IsZero() {
+		alsoPropagate("HPEXPIREAT", string(p[0].Bulk), strconv.FormatInt(expire.UnixMilli(), 10), "FIELDS", "1", string(p[1].Bulk))
+	}
+	return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(value)}
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+// A negative count allows the same field to be returned several times
+func hrandfield(p []resp.Payload) resp.Payload {
+	if len(p) < 1 || len(p) > 3 {
+		return missingArgumentsError
+	}
+	count, withCount := 1, len(p) >= 2
+	if withCount {
+		n, errPayload := parseRandomCount(string(p[1].Bulk))
+		if errPayload.DataType != "" {
+			return errPayload
+		}
+		count = n
+	}
+	withValues := len(p) == 3
+	if withValues && strings.ToUpper(string(p[2].Bulk)) != "WITHVALUES" {
+		return syntaxError
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if hash == nil {
+		if !withCount {
+			return resp.NullBulk
+		}
+		return bulkArray(nil)
+	}
+
+	var chosen []string
+	if count >= 0 {
+		for _, i := range randomIndexes(hash.len(), count) {
+			chosen = append(chosen, hash.names[i])
+		}
+	} else {
+		chosen = make([]string, -count)
+		for i := range chosen {
+			chosen[i] = hash.names[rand.Intn(hash.len())]
+		}
+	}
+
+	if !withCount {
+		return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(chosen[0])}
+	}
+	values := make([]string, 0, len(chosen)*2)
+	for _, f := range chosen {
+		values = append(values, f)
+		if withValues {
+			values = append(values, hash.fields[f].value)
+		}
+	}
+	return bulkArray(values)
+}
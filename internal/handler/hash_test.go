@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func TestHset(t *testing.T) {
+	del(args("hash"))
+
+	if response := hset(args("hash", "f1", "v1", "f2", "v2")); response.Num != 2 {
+		t.Errorf("Expected 2, got %v", response)
+	}
+	// Only new fields are counted
+	if response := hset(args("hash", "f1", "new", "f3", "v3")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := hsetnx(args("hash", "f1", "other")); response.Num != 0 {
+		t.Errorf("Expected 0, got %v", response)
+	}
+	if response := hmset(args("hash", "f4", "v4")); response.Str != "OK" {
+		t.Errorf("Expected OK, got %v", response)
+	}
+	if response := hget(args("hash", "f1")); string(response.Bulk) != "new" {
+		t.Errorf("Expected new, got %v", response)
+	}
+	response := hmget(args("hash", "f2", "nonexisting"))
+	if string(response.Array[0].Bulk) != "v2" || !response.Array[1].Null {
+		t.Errorf("Unexpected values %v", response)
+	}
+	if got := sortedBulks(hkeys(args("hash"))); !reflect.DeepEqual(got, []string{"f1", "f2", "f3", "f4"}) {
+		t.Errorf("Unexpected fields %v", got)
+	}
+	if got := sortedBulks(hvals(args("hash"))); !reflect.DeepEqual(got, []string{"new", "v2", "v3", "v4"}) {
+		t.Errorf("Unexpected values %v", got)
+	}
+	if got := hgetall(args("hash")); len(got.Array) != 8 {
+		t.Errorf("Expected 8 elements, got %v", got)
+	}
+	if response := hlen(args("hash")); response.Num != 4 {
+		t.Errorf("Expected 4, got %v", response)
+	}
+	if response := hstrlen(args("hash", "f1")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	if response := hexists(args("hash", "f5")); response.Num != 0 {
+		t.Errorf("Expected 0, got %v", response)
+	}
+}
+
+func TestHdel(t *testing.T) {
+	del(args("hash"))
+	hset(args("hash", "f1", "v1", "f2", "v2"))
+
+	if response := hdel(args("hash", "f1", "nonexisting")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := hdel(args("hash", "f2")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if exist(args("hash")).Num != 0 {
+		t.Errorf("Expected empty hash to be deleted")
+	}
+}
+
+func TestHincrby(t *testing.T) {
+	del(args("hash"))
+	hset(args("hash", "str", "abc", "max", "9223372036854775807"))
+
+	if response := hincrby(args("hash", "counter", "5")); response.Num != 5 {
+		t.Errorf("Expected 5, got %v", response)
+	}
+	if response := hincrby(args("hash", "counter", "-7")); response.Num != -2 {
+		t.Errorf("Expected -2, got %v", response)
+	}
+	for _, bad := range [][]string{{"hash", "str", "1"}, {"hash", "max", "1"}, {"hash", "counter", "x"}} {
+		if response := hincrby(args(bad...)); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected error for %v, got %v", bad, response)
+		}
+	}
+	if response := hincrbyfloat(args("hash", "float", "10.5")); string(response.Bulk) != "10.5" {
+		t.Errorf("Expected 10.5, got %v", response)
+	}
+	if response := hincrbyfloat(args("hash", "counter", "0.25")); string(response.Bulk) != "-1.75" {
+		t.Errorf("Expected -1.75, got %v", response)
+	}
+}
+
+func TestHrandfield(t *testing.T) {
+	del(args("hash"))
+	hset(args("hash", "f1", "v1", "f2", "v2"))
+
+	if response := hrandfield(args("hash")); string(response.Bulk) != "f1" && string(response.Bulk) != "f2" {
+		t.Errorf("Unexpected field %v", response)
+	}
+	fields := sortedBulks(hrandfield(args("hash", "5")))
+	if !reflect.DeepEqual(fields, []string{"f1", "f2"}) {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	values := bulks(hrandfield(args("hash", "-3", "WITHVALUES")))
+	if len(values) != 6 || !slices.Contains([]string{"v1", "v2"}, values[1]) {
+		t.Errorf("Unexpected fields %v", values)
+	}
+	for _, count := range []string{"-9223372036854775808", "4611686018427387904", "-100000000"} {
+		if response := hrandfield(args("hash", count, "WITHVALUES")); response.ErrorCode() != "ERR" {
+			t.Errorf("Expected count %s to be out of range, got %v", count, response)
+		}
+	}
+	if response := hrandfield(args("nonexisting")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+
+	// Deleted fields are not picked anymore
+	hset(args("hash", "f3", "v3", "f4", "v4"))
+	hdel(args("hash", "f1", "f3"))
+	fields = sortedBulks(hrandfield(args("hash", "5")))
+	if !reflect.DeepEqual(fields, []string{"f2", "f4"}) {
+		t.Errorf("Unexpected fields %v after HDEL", fields)
+	}
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	del(args("hash"))
+	hset(args("hash", "f1", "v1", "f2", "v2", "f3", "v3"))
+
+	response := hpexpire(args("hash", "50", "FIELDS", "2", "f1", "nonexisting"))
+	if response.Array[0].Num != hfieldSet || response.Array[1].Num != hfieldNotFound {
+		t.Errorf("Unexpected reply %v", response)
+	}
+	if response := hexpire(args("hash", "100", "NX", "FIELDS", "1", "f1")); response.Array[0].Num != hfieldNotSet {
+		t.Errorf("Expected NX to fail, got %v", response)
+	}
+	if response := hexpire(args("hash", "100", "GT", "FIELDS", "1", "f2")); response.Array[0].Num != hfieldNotSet {
+		t.Errorf("Expected GT to fail on a field without TTL, got %v", response)
+	}
+	if response := hexpire(args("hash", "100", "FIELDS", "1", "f2")); response.Array[0].Num != hfieldSet {
+		t.Errorf("Expected TTL to be set, got %v", response)
+	}
+	if response := httl(args("hash", "FIELDS", "3", "f2", "f3", "nonexisting")); response.Array[0].Num != 100 || response.Array[1].Num != hfieldNoTTL || response.Array[2].Num != hfieldNotFound {
+		t.Errorf("Unexpected TTL %v", response)
+	}
+	if response := hpersist(args("hash", "FIELDS", "1", "f2")); response.Array[0].Num != hfieldPersisted {
+		t.Errorf("Expected TTL to be removed, got %v", response)
+	}
+	if response := hexpire(args("hash", "10", "FIELDS", "2", "f1")); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+
+	// Expired fields are not visible to reads
+	time.Sleep(60 * time.Millisecond)
+	if response := hget(args("hash", "f1")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+	if response := hlen(args("hash")); response.Num != 2 {
+		t.Errorf("Expected 2, got %v", response)
+	}
+
+	// A time in the past deletes the field, and the hash with its last field
+	if response := hpexpireat(args("hash", "1", "FIELDS", "2", "f2", "f3")); response.Array[0].Num != hfieldDeleted {
+		t.Errorf("Expected field to be deleted, got %v", response)
+	}
+	if exist(args("hash")).Num != 0 {
+		t.Errorf("Expected hash to be deleted")
+	}
+}
+
+func TestHashFieldExpireAof(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "f2", "v2"), aof)
+	processRequest(nil, request("HEXPIRE", "hash", "100", "FIELDS", "1", "f1"), aof)
+	expected := hpexpiretime(args("hash", "FIELDS", "1", "f1")).Array[0].Num
+
+	content, _ := os.ReadFile(aof.file.Name())
+	if !strings.Contains(string(content), "HPEXPIREAT") || strings.Contains(string(content), "HEXPIRE\r") {
+		t.Errorf("Expected HEXPIRE to be logged as HPEXPIREAT, got %q", content)
+	}
+
+	keyspace = map[string]*redisObject{}
+	aof.load()
+	if got := hpexpiretime(args("hash", "FIELDS", "1", "f1")).Array[0].Num; got != expected {
+		t.Errorf("Expected expire time %d after replay, got %d", expected, got)
+	}
+}
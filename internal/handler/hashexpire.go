@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Per field expiration of hashes.
+// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+// Relative times are converted to HPEXPIREAT before the command is logged
+// and executed, see aofRewrites.
+
+const (
+	hfieldNotFound  = -2
+	hfieldNoTTL     = -1
+	hfieldNotSet    = 0
+	hfieldSet       = 1
+	hfieldDeleted   = 2
+	hfieldPersisted = 1
+)
+
+// parseFields parses "FIELDS numfields field [field ...]" at the end of p
+func parseFields(p []resp.Payload) ([]string, resp.Payload) {
+	if len(p) < 3 || strings.ToUpper(string(p[0].Bulk)) != "FIELDS" {
+		return nil, resp.NewError("ERR", "Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(string(p[1].Bulk))
+	if err != nil || n <= 0 {
+		return nil, resp.NewError("ERR", "Parameter `numFields` should be greater than 0")
+	}
+	if n != len(p)-2 {
+		return nil, resp.NewError("ERR", "The `numfields` parameter must match the number of arguments")
+	}
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i] = string(p[i+2].Bulk)
+	}
+	return fields, resp.Payload{}
+}
+
+// parseExpireTime converts the time argument of an expire command to an
+// absolute time in milliseconds
+func parseExpireTime(s string, unit time.Duration, absolute bool, now time.Time) (int64, bool) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	factor := int64(unit / time.Millisecond)
+	if v > math.MaxInt64/factor {
+		return 0, false
+	}
+	ms := v * factor
+	if !absolute {
+		if ms > math.MaxInt64-now.UnixMilli() {
+			return 0, false
+		}
+		ms += now.UnixMilli()
+	}
+	return ms, true
+}
+
+// expireAllowed checks the NX, XX, GT and LT conditions, no expiration is
+// handled as an infinite time to live
+func expireAllowed(condition string, current, when time.Time) bool {
+	switch condition {
+	case "NX":
+		return current.IsZero()
+	case "XX":
+		return !current.IsZero()
+	case "GT":
+		return !current.IsZero() && when.After(current)
+	case "LT":
+		return current.IsZero() || when.Before(current)
+	}
+	return true
+}
+
+func hexpireGeneric(p []resp.Payload, unit time.Duration, absolute bool) resp.Payload {
+	if len(p) < 5 {
+		return missingArgumentsError
+	}
+	now := time.Now()
+	ms, ok := parseExpireTime(string(p[1].Bulk), unit, absolute, now)
+	if !ok {
+		return resp.NewError("ERR", "invalid expire time")
+	}
+	rest := p[2:]
+	var condition string
+	switch c := strings.ToUpper(string(rest[0].Bulk)); c {
+	case "NX", "XX", "GT", "LT":
+		condition = c
+		rest = rest[1:]
+	}
+	fields, errPayload := parseFields(rest)
+	if fields == nil {
+		return errPayload
+	}
+
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	when := time.UnixMilli(ms)
+	array := make([]resp.Payload, len(fields))
+	for i, f := range fields {
+		code := hfieldNotFound
+		if hash != nil {
+			if v, exists := hash.fields[f]; exists {
+				switch {
+				case !expireAllowed(condition, v.expire, when):
+					code = hfieldNotSet
+				case !when.After(now):
+					hash.remove(f)
+					dirty++
+					code = hfieldDeleted
+				default:
+					hash.setExpire(f, when)
+					volatileHashes[string(p[0].Bulk)] = struct{}{}
+					dirty++
+					code = hfieldSet
+				}
+			}
+		}
+		array[i] = resp.Payload{DataType: string(resp.INTEGER), Num: code}
+	}
+	if hash != nil && hash.len() == 0 {
+		delete(keyspace, string(p[0].Bulk))
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+func hexpire(p []resp.Payload) resp.Payload    { return hexpireGeneric(p, time.Second, false) }
+func hpexpire(p []resp.Payload) resp.Payload   { return hexpireGeneric(p, time.Millisecond, false) }
+func hexpireat(p []resp.Payload) resp.Payload  { return hexpireGeneric(p, time.Second, true) }
+func hpexpireat(p []resp.Payload) resp.Payload { return hexpireGeneric(p, time.Millisecond, true) }
+
+// fieldsTTL replies with a value computed from the expiration of each field
+func fieldsTTL(p []resp.Payload, value func(expire time.Time) int) resp.Payload {
+	if len(p) < 4 {
+		return missingArgumentsError
+	}
+	fields, errPayload := parseFields(p[1:])
+	if fields == nil {
+		return errPayload
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	array := make([]resp.Payload, len(fields))
+	for i, f := range fields {
+		code := hfieldNotFound
+		if hash != nil {
+			if v, exists := hash.fields[f]; exists {
+				code = hfieldNoTTL
+				if !v.expire.IsZero() {
+					code = value(v.expire)
+				}
+			}
+		}
+		array[i] = resp.Payload{DataType: string(resp.INTEGER), Num: code}
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+// HTTL key FIELDS numfields field [field ...]
+func httl(p []resp.Payload) resp.Payload {
+	return fieldsTTL(p, func(expire time.Time) int {
+		return int((time.Until(expire) + 500*time.Millisecond) / time.Second)
+	})
+}
+
+func hpttl(p []resp.Payload) resp.Payload {
+	return fieldsTTL(p, func(expire time.Time) int { return int(time.Until(expire).Milliseconds()) })
+}
+
+func hexpiretime(p []resp.Payload) resp.Payload {
+	return fieldsTTL(p, func(expire time.Time) int { return int(expire.Unix()) })
+}
+
+func hpexpiretime(p []resp.Payload) resp.Payload {
+	return fieldsTTL(p, func(expire time.Time) int { return int(expire.UnixMilli()) })
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func hpersist(p []resp.Payload) resp.Payload {
+	if len(p) < 4 {
+		return missingArgumentsError
+	}
+	fields, errPayload := parseFields(p[1:])
+	if fields == nil {
+		return errPayload
+	}
+	hash, ok := lookupHash(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	array := make([]resp.Payload, len(fields))
+	for i, f := range fields {
+		code := hfieldNotFound
+		if hash != nil {
+			if v, exists := hash.fields[f]; exists {
+				code = hfieldNoTTL
+				if !v.expire.IsZero() {
+					hash.setExpire(f, time.Time{})
+					dirty++
+					code = hfieldPersisted
+				}
+			}
+		}
+		array[i] = resp.Payload{DataType: string(resp.INTEGER), Num: code}
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+// rewriteHexpire converts HEXPIRE, HPEXPIRE and HEXPIREAT to HPEXPIREAT with
+// an absolute time in milliseconds, so replaying the AOF does not extend the
+// time to live of the fields
+func rewriteHexpire(argv []resp.Payload) []resp.Payload {
+	if len(argv) < 3 {
+		return argv
+	}
+	unit, absolute := time.Second, false
+	switch strings.ToUpper(string(argv[0].Bulk)) {
+	case "HPEXPIRE":
+		unit = time.Millisecond
+	case "HEXPIREAT":
+		absolute = true
+	}
+	ms, ok := parseExpireTime(string(argv[2].Bulk), unit, absolute, time.Now())
+	if !ok {
+		return argv
+	}
+	rewritten := append([]resp.Payload{}, argv...)
+	rewritten[0] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte("HPEXPIREAT")}
+	rewritten[2] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(strconv.FormatInt(ms, 10))}
+	return rewritten
+}
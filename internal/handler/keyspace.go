@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Single keyspace shared by every data type.
+// Each key holds exactly one typed object, so DEL, EXISTS, TYPE and
+// expiration behave the same whatever the value is.
+
+type objectType int
+
+const (
+	stringType objectType = iota
+	hashType
+	listType
+	setType
+	zsetType
+)
+
+func (t objectType) String() string {
+	switch t {
+	case stringType:
+		return "string"
+	case hashType:
+		return "hash"
+	case listType:
+		return "list"
+	case setType:
+		return "set"
+	case zsetType:
+		return "zset"
+	}
+	return "none"
+}
+
+// value holds a string for stringType, a *redisHash for hashType
+// a *quicklist for listType, a *redisSet for setType and a *sortedSet for
+// zsetType
+type redisObject struct {
+	kind   objectType
+	value  interface{}
+	expire time.Time
+}
+
+var keyspace = map[string]*redisObject{}
+
+// Keys with a time to live and hashes with fields having one, sampled by the
+// active expire cycle. Entries are removed lazily by the cycle once the key
+// no longer expires.
+var volatileKeys = map[string]struct{}{}
+var volatileHashes = map[string]struct{}{}
+
+// Number of changes made to the dataset. Write commands increment it when
+// they modify it, a command is only propagated to the AOF when it did.
+var dirty int
+
+// Commands to log to the AOF in place of the command being executed, set by
+// commands whose effect is not deterministic, as SPOP which is logged as the
+// SREM of the members it popped
+var propagateAs []resp.Payload
+
+// alsoPropagate replaces the command being executed by argv in the AOF
+func alsoPropagate(argv ...string) {
+	propagateAs = append(propagateAs, bulkArray(argv))
+}
+
+// Counters reported by INFO
+var stats struct {
+	expiredKeys           int
+	expiredSubkeys        int
+	expiredStalePerc      float64
+	expiredTimeCapReached int
+	expireCycleTime       time.Duration
+}
+
+// keyspaceLock is held by the dispatcher for the whole execution of a command,
+// handlers do not lock on their own.
+var keyspaceLock sync.Mutex
+
+var wrongTypeError = resp.NewError("WRONGTYPE", "Operation against a key holding the wrong kind of value")
+
+func (o *redisObject) isExpired(now time.Time) bool {
+	return !o.expire.IsZero() && !o.expire.After(now)
+}
+
+// lookupKey returns the object stored at key, or nil if there is none.
+// Expired keys and expired hash fields are removed on access.
+func lookupKey(key string) *redisObject {
+	obj, ok := keyspace[key]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if obj.isExpired(now) {
+		delete(keyspace, key)
+		stats.expiredKeys++
+		return nil
+	}
+	if obj.kind == hashType {
+		h := obj.value.(*redisHash)
+		if n := h.expireFields(now); n > 0 {
+			stats.expiredSubkeys += n
+			if h.len() == 0 {
+				delete(keyspace, key)
+				return nil
+			}
+		}
+	}
+	return obj
+}
+
+// lookupTyped returns the object stored at key if it has the expected type.
+// A nil object with ok set to true means the key does not exist, ok is false
+// when the key holds another type.
+func lookupTyped(key string, kind objectType) (obj *redisObject, ok bool) {
+	obj = lookupKey(key)
+	if obj == nil {
+		return nil, true
+	}
+	if obj.kind != kind {
+		return nil, false
+	}
+	return obj, true
+}
+
+func setKey(key string, obj *redisObject) {
+	dirty++
+	keyspace[key] = obj
+	if !obj.expire.IsZero() {
+		volatileKeys[key] = struct{}{}
+	}
+}
+
+func setExpire(key string, obj *redisObject, when time.Time) {
+	obj.expire = when
+	volatileKeys[key] = struct{}{}
+	dirty++
+}
+
+func deleteKey(key string) bool {
+	if lookupKey(key) == nil {
+		return false
+	}
+	delete(keyspace, key)
+	dirty++
+	return true
+}
@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// List commands, values are stored as a quicklist
+
+func listPush(p []resp.Payload, left bool, onlyIfExists bool) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	obj, ok := lookupTyped(key, listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		if onlyIfExists {
+			return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+		}
+		obj = &redisObject{kind: listType, value: newQuicklist()}
+		setKey(key, obj)
+	}
+	l := obj.value.(*quicklist)
+	dirty += len(p) - 1
+	for _, v := range p[1:] {
+		if left {
+			l.pushHead(string(v.Bulk))
+		} else {
+			l.pushTail(string(v.Bulk))
+		}
+	}
+	signalKeyAsReady(key)
+	return resp.Payload{DataType: string(resp.INTEGER), Num: l.len()}
+}
+
+func lpush(p []resp.Payload) resp.Payload  { return listPush(p, true, false) }
+func rpush(p []resp.Payload) resp.Payload  { return listPush(p, false, false) }
+func lpushx(p []resp.Payload) resp.Payload { return listPush(p, true, true) }
+func rpushx(p []resp.Payload) resp.Payload { return listPush(p, false, true) }
+
+func listPop(p []resp.Payload, left bool) resp.Payload {
+	if len(p) != 1 && len(p) != 2 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	count := -1
+	if len(p) == 2 {
+		n, err := strconv.Atoi(string(p[1].Bulk))
+		if err != nil || n < 0 {
+			return resp.NewError("ERR", "value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	obj, ok := lookupTyped(key, listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		if count == -1 {
+			return resp.NullBulk
+		}
+		return resp.NullArray
+	}
+	l := obj.value.(*quicklist)
+
+	// Without count a single bulk string is returned, otherwise an array
+	if count == -1 {
+		v, _ := popListEnd(l, left)
+		dirty++
+		if l.len() == 0 {
+			delete(keyspace, key)
+		}
+		return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(v)}
+	}
+	values := make([]string, 0, min(count, l.len()))
+	for len(values) < count {
+		v, ok := popListEnd(l, left)
+		if !ok {
+			break
+		}
+		values = append(values, v)
+	}
+	dirty += len(values)
+	if l.len() == 0 {
+		delete(keyspace, key)
+	}
+	return bulkArray(values)
+}
+
+func popListEnd(l *quicklist, left bool) (string, bool) {
+	if left {
+		return l.popHead()
+	}
+	return l.popTail()
+}
+
+func lpop(p []resp.Payload) resp.Payload { return listPop(p, true) }
+func rpop(p []resp.Payload) resp.Payload { return listPop(p, false) }
+
+func llen(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	var length int
+	if obj != nil {
+		length = obj.value.(*quicklist).len()
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: length}
+}
+
+// normalizeIndex converts a negative index counted from the tail
+func normalizeIndex(index, length int) int {
+	if index < 0 {
+		index += length
+	}
+	return index
+}
+
+func lrange(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	start, err1 := strconv.Atoi(string(p[1].Bulk))
+	stop, err2 := strconv.Atoi(string(p[2].Bulk))
+	if err1 != nil || err2 != nil {
+		return notIntegerError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return bulkArray(nil)
+	}
+	l := obj.value.(*quicklist)
+	start = max(normalizeIndex(start, l.len()), 0)
+	stop = min(normalizeIndex(stop, l.len()), l.len()-1)
+	if start > stop {
+		return bulkArray(nil)
+	}
+	return bulkArray(l.slice(start, stop-start+1))
+}
+
+func lindex(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	index, err := strconv.Atoi(string(p[1].Bulk))
+	if err != nil {
+		return notIntegerError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.NullBulk
+	}
+	l := obj.value.(*quicklist)
+	index = normalizeIndex(index, l.len())
+	if index < 0 || index >= l.len() {
+		return resp.NullBulk
+	}
+	return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(l.get(index))}
+}
+
+func lset(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	index, err := strconv.Atoi(string(p[1].Bulk))
+	if err != nil {
+		return notIntegerError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.NewError("ERR", "no such key")
+	}
+	l := obj.value.(*quicklist)
+	index = normalizeIndex(index, l.len())
+	if index < 0 || index >= l.len() {
+		return resp.NewError("ERR", "index out of range")
+	}
+	l.set(index, string(p[2].Bulk))
+	dirty++
+	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+}
+
+// LREM key count element
+// count > 0 removes from head to tail, count < 0 from tail to head and
+// count = 0 removes all matching elements
+func lrem(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	count, err := strconv.Atoi(string(p[1].Bulk))
+	if err != nil {
+		return notIntegerError
+	}
+	element := string(p[2].Bulk)
+
+	obj, ok := lookupTyped(key, listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	l := obj.value.(*quicklist)
+
+	var it *quicklistIter
+	if count < 0 {
+		it = l.iterator(l.len()-1, true)
+		count = -count
+	} else {
+		it = l.iterator(0, false)
+	}
+	removed := 0
+	for count == 0 || removed < count {
+		v, ok := it.next()
+		if !ok {
+			break
+		}
+		if v == element {
+			it.remove()
+			removed++
+		}
+	}
+	dirty += removed
+	if l.len() == 0 {
+		delete(keyspace, key)
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: removed}
+}
+
+func ltrim(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	start, err1 := strconv.Atoi(string(p[1].Bulk))
+	stop, err2 := strconv.Atoi(string(p[2].Bulk))
+	if err1 != nil || err2 != nil {
+		return notIntegerError
+	}
+	obj, ok := lookupTyped(key, listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+	}
+	l := obj.value.(*quicklist)
+	start = max(normalizeIndex(start, l.len()), 0)
+	stop = min(normalizeIndex(stop, l.len()), l.len()-1)
+	if start > stop {
+		delete(keyspace, key)
+		dirty++
+		return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+	}
+	for tail := l.len() - 1 - stop; tail > 0; tail-- {
+		l.popTail()
+		dirty++
+	}
+	for ; start > 0; start-- {
+		l.popHead()
+		dirty++
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func linsert(p []resp.Payload) resp.Payload {
+	if len(p) != 4 {
+		return missingArgumentsError
+	}
+	var after bool
+	switch strings.ToUpper(string(p[1].Bulk)) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return syntaxError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	l := obj.value.(*quicklist)
+	it := l.iterator(0, false)
+	for {
+		v, ok := it.next()
+		if !ok {
+			return resp.Payload{DataType: string(resp.INTEGER), Num: -1}
+		}
+		if v == string(p[2].Bulk) {
+			it.insert(string(p[3].Bulk), after)
+			dirty++
+			return resp.Payload{DataType: string(resp.INTEGER), Num: l.len()}
+		}
+	}
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lpos(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	rank, count, maxlen := 1, -1, 0
+	for i := 2; i < len(p); i += 2 {
+		if i+1 >= len(p) {
+			return syntaxError
+		}
+		n, err := strconv.Atoi(string(p[i+1].Bulk))
+		if err != nil {
+			return notIntegerError
+		}
+		switch strings.ToUpper(string(p[i].Bulk)) {
+		case "RANK":
+			if n == 0 {
+				return resp.NewError("ERR", "RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return resp.NewError("ERR", "COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return resp.NewError("ERR", "MAXLEN can't be negative")
+			}
+			maxlen = n
+		default:
+			return syntaxError
+		}
+	}
+
+	obj, ok := lookupTyped(string(p[0].Bulk), listType)
+	if !ok {
+		return wrongTypeError
+	}
+	matches := []resp.Payload{}
+	if obj != nil {
+		l := obj.value.(*quicklist)
+		reverse := rank < 0
+		skip := rank - 1
+		it := l.iterator(0, false)
+		if reverse {
+			skip = -rank - 1
+			it = l.iterator(l.len()-1, true)
+		}
+		for scanned := 0; maxlen == 0 || scanned < maxlen; scanned++ {
+			v, ok := it.next()
+			if !ok {
+				break
+			}
+			if v != string(p[1].Bulk) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			index := scanned
+			if reverse {
+				index = l.len() - 1 - scanned
+			}
+			matches = append(matches, resp.Payload{DataType: string(resp.INTEGER), Num: index})
+			if count == -1 || (count > 0 && len(matches) == count) {
+				break
+			}
+		}
+	}
+
+	if count == -1 {
+		if len(matches) == 0 {
+			return resp.NullBulk
+		}
+		return matches[0]
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: matches}
+}
+
+func parseListEnd(s string) (left bool, ok bool) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// listMove pops an element from source and pushes it to destination.
+// The returned value is false when source does not exist.
+func listMove(source, destination string, fromLeft, toLeft bool) (string, bool, resp.Payload) {
+	src, ok := lookupTyped(source, listType)
+	if !ok {
+		return "", false, wrongTypeError
+	}
+	dst, ok := lookupTyped(destination, listType)
+	if !ok {
+		return "", false, wrongTypeError
+	}
+	if src == nil {
+		return "", false, resp.Payload{}
+	}
+	srcList := src.value.(*quicklist)
+	v, _ := popListEnd(srcList, fromLeft)
+	dirty++
+	if dst == nil {
+		dst = &redisObject{kind: listType, value: newQuicklist()}
+		setKey(destination, dst)
+	}
+	dstList := dst.value.(*quicklist)
+	if toLeft {
+		dstList.pushHead(v)
+	} else {
+		dstList.pushTail(v)
+	}
+	signalKeyAsReady(destination)
+	if srcList.len() == 0 {
+		delete(keyspace, source)
+	}
+	return v, true, resp.Payload{}
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmove(p []resp.Payload) resp.Payload {
+	if len(p) != 4 {
+		return missingArgumentsError
+	}
+	fromLeft, ok1 := parseListEnd(string(p[2].Bulk))
+	toLeft, ok2 := parseListEnd(string(p[3].Bulk))
+	if !ok1 || !ok2 {
+		return syntaxError
+	}
+	v, moved, errPayload := listMove(string(p[0].Bulk), string(p[1].Bulk), fromLeft, toLeft)
+	if errPayload.DataType != "" {
+		return errPayload
+	}
+	if !moved {
+		return resp.NullBulk
+	}
+	return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(v)}
+}
+
+func rpoplpush(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	return lmove([]resp.Payload{p[0], p[1], {Bulk: []byte("RIGHT")}, {Bulk: []byte("LEFT")}})
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func args(values ...string) []resp.Payload {
+	p := make([]resp.Payload, len(values))
+	for i, v := range values {
+		p[i] = resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(v)}
+	}
+	return p
+}
+
+// bulks returns the elements of the reply as a RESP2 client reads them
+func bulks(p resp.Payload) []string {
+	values := []string{}
+	for _, v := range p.ToResp2().Array {
+		values = append(values, string(v.Bulk))
+	}
+	return values
+}
+
+func TestListPushPop(t *testing.T) {
+	del(args("list"))
+
+	response := rpush(args("list", "a", "b", "c"))
+	if response.Num != 3 {
+		t.Errorf("Expected 3, got %d", response.Num)
+	}
+	response = lpush(args("list", "z"))
+	if response.Num != 4 {
+		t.Errorf("Expected 4, got %d", response.Num)
+	}
+	response = lpushx(args("nonexisting", "a"))
+	if response.Num != 0 {
+		t.Errorf("Expected 0, got %d", response.Num)
+	}
+
+	response = lrange(args("list", "0", "-1"))
+	if got := bulks(response); !reflect.DeepEqual(got, []string{"z", "a", "b", "c"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+
+	response = lpop(args("list"))
+	if response.DataType != string(resp.BULKSTRING) || string(response.Bulk) != "z" {
+		t.Errorf("Expected z, got %v", response)
+	}
+	response = rpop(args("list", "5"))
+	if got := bulks(response); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("Unexpected pop %v", got)
+	}
+
+	// Empty lists are removed from the keyspace
+	if exist(args("list")).Num != 0 {
+		t.Errorf("Expected list to be deleted")
+	}
+	if response := lpop(args("list")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+}
+
+func TestListIndexes(t *testing.T) {
+	del(args("list"))
+	rpush(args("list", "a", "b", "c", "b", "a"))
+
+	if response := lindex(args("list", "-1")); string(response.Bulk) != "a" {
+		t.Errorf("Expected a, got %v", response)
+	}
+	if response := lindex(args("list", "10")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+	if response := lset(args("list", "1", "B")); response.Str != "OK" {
+		t.Errorf("Expected OK, got %v", response)
+	}
+	if response := lset(args("list", "9", "B")); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+	if response := lpos(args("list", "a", "RANK", "-1")); response.Num != 4 {
+		t.Errorf("Expected 4, got %v", response)
+	}
+	if response := lpos(args("list", "a", "COUNT", "0")); !reflect.DeepEqual([]int{response.Array[0].Num, response.Array[1].Num}, []int{0, 4}) {
+		t.Errorf("Expected [0 4], got %v", response)
+	}
+	if response := linsert(args("list", "AFTER", "c", "d")); response.Num != 6 {
+		t.Errorf("Expected 6, got %v", response)
+	}
+	if response := linsert(args("list", "BEFORE", "x", "d")); response.Num != -1 {
+		t.Errorf("Expected -1, got %v", response)
+	}
+	if response := lrem(args("list", "-1", "a")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if got := bulks(lrange(args("list", "0", "-1"))); !reflect.DeepEqual(got, []string{"a", "B", "c", "d", "b"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+	ltrim(args("list", "1", "-2"))
+	if got := bulks(lrange(args("list", "0", "-1"))); !reflect.DeepEqual(got, []string{"B", "c", "d"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+	if response := llen(args("list")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+}
+
+func TestLmove(t *testing.T) {
+	del(args("src", "dst"))
+	rpush(args("src", "a", "b"))
+
+	if response := lmove(args("src", "dst", "RIGHT", "LEFT")); string(response.Bulk) != "b" {
+		t.Errorf("Expected b, got %v", response)
+	}
+	if response := rpoplpush(args("src", "dst")); string(response.Bulk) != "a" {
+		t.Errorf("Expected a, got %v", response)
+	}
+	if got := bulks(lrange(args("dst", "0", "-1"))); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+	if response := lmove(args("src", "dst", "LEFT", "LEFT")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+
+	// Rotating a list onto itself
+	lmove(args("dst", "dst", "LEFT", "RIGHT"))
+	if got := bulks(lrange(args("dst", "0", "-1"))); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+
+	set(args("str", "value"))
+	if response := lmove(args("dst", "str", "LEFT", "LEFT")); response.Str != wrongTypeError.Str {
+		t.Errorf("Expected WRONGTYPE, got %v", response)
+	}
+}
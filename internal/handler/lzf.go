@@ -0,0 +1,108 @@
+package handler
+
+import "errors"
+
+// LZF compression, used by Redis for the long strings of RDB files. The
+// compressed data is a sequence of literal runs and back references:
+//
+//	000LLLLL <L+1 bytes>          literal run of 1 to 32 bytes
+//	LLLooooo oooooooo             copy L+2 bytes from o+1 bytes back
+//	111ooooo LLLLLLLL oooooooo    copy L+9 bytes from o+1 bytes back
+
+const (
+	lzfMaxLiteral = 32
+	lzfMaxOffset  = 1 << 13
+	lzfMaxMatch   = 7 + 255 + 2
+	lzfHashLog    = 14
+)
+
+var errLzfCorrupted = errors.New("invalid LZF compressed data")
+
+// lzfDecompress decompresses in, which must expand to exactly n bytes
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLiteral {
+			length := ctrl + 1
+			if i+length > len(in) || len(out)+length > n {
+				return nil, errLzfCorrupted
+			}
+			out = append(out, in[i:i+length]...)
+			i += length
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i == len(in) {
+				return nil, errLzfCorrupted
+			}
+			length += int(in[i])
+			i++
+		}
+		if i == len(in) {
+			return nil, errLzfCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > n {
+			return nil, errLzfCorrupted
+		}
+		// The reference may overlap the bytes being copied
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, errLzfCorrupted
+	}
+	return out, nil
+}
+
+// lzfCompress compresses in, back references are found through a hash table
+// of the last position of each 3 bytes sequence
+func lzfCompress(in []byte) []byte {
+	var out []byte
+	var table [1 << lzfHashLog]int
+	literal := 0
+	flush := func(end int) {
+		for start := end - literal; start < end; start += lzfMaxLiteral {
+			n := min(end-start, lzfMaxLiteral)
+			out = append(out, byte(n-1))
+			out = append(out, in[start:start+n]...)
+		}
+		literal = 0
+	}
+
+	for i := 0; i < len(in); {
+		if i+2 < len(in) {
+			v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+			h := (v * 2654435761) >> (32 - lzfHashLog)
+			ref := table[h] - 1
+			table[h] = i + 1
+			if ref >= 0 && i-ref <= lzfMaxOffset && in[ref] == in[i] && in[ref+1] == in[i+1] && in[ref+2] == in[i+2] {
+				length := 3
+				for length < lzfMaxMatch && i+length < len(in) && in[ref+length] == in[i+length] {
+					length++
+				}
+				flush(i)
+				off := i - ref - 1
+				if l := length - 2; l < 7 {
+					out = append(out, byte(l<<5|off>>8))
+				} else {
+					out = append(out, byte(7<<5|off>>8), byte(l-7))
+				}
+				out = append(out, byte(off))
+				i += length
+				continue
+			}
+		}
+		literal++
+		i++
+	}
+	flush(len(in))
+	return out
+}
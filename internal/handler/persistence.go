@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -13,82 +16,467 @@ import (
 
 // Implement persistence for the redis lite server
 // using Append only file.
-// All SET commands are written in the file, which sync is forced every 1s.
-// At startup, the file is read and applied to the in-memory data structure
+// The write commands are appended to the last incremental file of the AOF,
+// see aofmanifest.go, which is synced to disk as set by appendfsync: after
+// every write with always, every second with everysec, or when the OS
+// decides with no.
+// At startup, the files are read and applied to the in-memory data structure
 
 type Aof struct {
-	file *os.File
-	mu   sync.Mutex
+	// Directory of the AOF files, and the incremental file being appended to,
+	// with the sealer encrypting its records when encryption is enabled
+	dir      string
+	manifest *aofManifest
+	file     *os.File
+	sealer   *sealer
+	mu       sync.Mutex
+	// The last incremental file ended with a partial command, dropped when
+	// loading it
+	truncated bool
+
+	// Size of the AOF files, and the size of the base file which is the
+	// reference of auto-aof-rewrite-percentage
+	size     int64
+	baseSize int64
+
+	rewriting      bool
+	rewrites       int
+	lastRewriteErr error
+
+	// What failed to be written, kept to be written again before anything
+	// else, and the error writes are refused with until it is written
+	pending      []byte
+	lastWriteErr error
+
+	// Data was written since the last fsync, and the start of the everysec
+	// fsync in progress
+	dirty      bool
+	fsyncStart time.Time
+	// A write happened while the fsync in progress was late, counted once
+	// per fsync
+	fsyncDelayed    bool
+	delayedFsyncs   int
+	fsyncLatency    time.Duration
+	maxFsyncLatency time.Duration
+
+	// Closed to stop the everysec fsync goroutine, which closes stopped
+	stop    chan struct{}
+	stopped chan struct{}
 }
 
-func NewAof() (*Aof, error) {
+// An everysec fsync running for longer than this is reported as delayed, the
+// writes meanwhile may be lost on a crash for longer than the promised second
+const aofFsyncDelay = 2 * time.Second
 
-	// aof file is located in /var/data/redis-lite/database.aof
-	dbDir := "data/redis-lite"
-	dbFile := "database.aof"
+// NewAof loads the dataset and opens the AOF, both in the dir directory. The
+// dataset is read from the AOF when appendonly is set, and from the snapshot
+// file otherwise, in which case commands are not logged until appendonly is
+// set at runtime. Nothing is written to dir when appendonly is no and save
+// rules are disabled.
+func NewAof() (*Aof, error) {
+	if err := loadKeys(); err != nil {
+		return nil, err
+	}
+	dir, _ := serverConfig.Get("dir")
+	if serverConfig.Enum("appendonly") == "no" {
+		if err := loadSnapshot(snapshotPath()); err != nil {
+			return nil, err
+		}
+		return &Aof{dir: dir}, nil
+	}
 
-	err := os.MkdirAll(dbDir, os.ModePerm)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	aof, err := openAof(dir)
 	if err != nil {
 		return nil, err
 	}
+	aof.startFsync()
+	return aof, nil
+}
+
+// startFsync starts the goroutine syncing the file every second, which runs
+// until Close
+func (a *Aof) startFsync() {
+	if a.stop != nil {
+		return
+	}
+	a.stop, a.stopped = make(chan struct{}), make(chan struct{})
+	go a.fsyncEverySecond()
+}
 
-	f, err := os.OpenFile(filepath.Join(dbDir, dbFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// openAof loads the AOF files of dir and opens the last incremental file
+func openAof(dir string) (*Aof, error) {
+	m, err := loadManifest(dir)
 	if err != nil {
 		return nil, err
 	}
-
-	aof := &Aof{
-		file: f,
+	// A single AOF file of older versions becomes the base file
+	if m.base == nil && len(m.incrs) == 0 {
+		if _, err := os.Stat(filepath.Join(dir, aofName())); err == nil {
+			m.base = &aofFile{name: aofName(), seq: 1, kind: aofBaseType}
+		}
 	}
 
+	aof := &Aof{dir: dir, manifest: m}
 	// Replay commands and apply to database
-	aof.Read()
-	// Go routine to fsync every 1 s
-	go func() {
-		for {
-			aof.mu.Lock()
-			aof.file.Sync()
-			aof.mu.Unlock()
+	if err := aof.load(); err != nil {
+		return nil, err
+	}
 
-			time.Sleep(time.Second)
+	if m.lastIncr() == nil {
+		m.incrs = append(m.incrs, aofFile{name: incrName(m.nextIncrSeq()), seq: m.nextIncrSeq(), kind: aofIncrType})
+		if err := m.save(dir); err != nil {
+			return nil, err
 		}
-	}()
-
+	}
+	aof.file, aof.sealer, err = openIncr(filepath.Join(dir, m.lastIncr().name), 0)
+	if err != nil {
+		return nil, err
+	}
+	// Plain commands are not appended to an AOF encrypted since, and a torn
+	// record dropped would have its nonce reused, see sealer.seal
+	if currentKeys() != nil && (aof.sealer == nil || aof.truncated) {
+		aof.mu.Lock()
+		err = aof.openNewIncr()
+		aof.mu.Unlock()
+		if err != nil {
+			aof.Close()
+			return nil, err
+		}
+	}
+	aof.size, aof.baseSize = aof.filesSize()
 	return aof, nil
 }
 
+// openIncr opens an incremental file to append to. A new file is encrypted
+// when encryption is enabled, an existing one keeps its format.
+func openIncr(name string, flag int) (*os.File, *sealer, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND|flag, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	var s *sealer
+	if err == nil && info.Size() == 0 {
+		if s = newSealer(); s != nil {
+			_, err = f.Write(s.header())
+		}
+	} else if err == nil {
+		header := make([]byte, len(sealedMagic))
+		if n, _ := f.ReadAt(header, 0); isSealed(header[:n]) {
+			s, err = resumeSealer(f)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, s, nil
+}
+
+// filesSize returns the size of the files of the manifest, and of the base
+// file alone
+func (a *Aof) filesSize() (size, baseSize int64) {
+	if a.manifest.base != nil {
+		if info, err := os.Stat(filepath.Join(a.dir, a.manifest.base.name)); err == nil {
+			baseSize = info.Size()
+		}
+	}
+	size = baseSize
+	for _, incr := range a.manifest.incrs {
+		if info, err := os.Stat(filepath.Join(a.dir, incr.name)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, baseSize
+}
+
+// Close stops the fsync goroutine, syncs what was written and closes the
+// file, commands are not logged from then on
 func (a *Aof) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		<-a.stopped
+		a.stop = nil
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return a.file.Close()
+	return a.closeFile()
+}
+
+// closeFile syncs and closes the file, a.mu must be held
+func (a *Aof) closeFile() error {
+	if a.file == nil {
+		return nil
+	}
+	if a.dirty && serverConfig.Enum("appendfsync") != "no" {
+		a.fsync(a.file)
+	}
+	if len(a.pending) > 0 {
+		log.Printf("Closing the AOF with %d bytes that could not be written", len(a.pending))
+	}
+	err := a.file.Close()
+	a.file, a.sealer, a.dirty = nil, nil, false
+	a.pending, a.lastWriteErr = nil, nil
+	return err
+}
+
+// fsyncEverySecond syncs the file every second with the everysec policy. The
+// lock is only held to take the file, so writers are not stalled by a slow
+// disk.
+func (a *Aof) fsyncEverySecond() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		if serverConfig.Enum("appendfsync") != "everysec" {
+			continue
+		}
+		a.mu.Lock()
+		f, dirty := a.file, a.dirty
+		a.dirty = false
+		start := time.Now()
+		if dirty {
+			a.fsyncStart = start
+		}
+		a.mu.Unlock()
+		if !dirty {
+			continue
+		}
+
+		err := f.Sync()
+		latency := time.Since(start)
+		// A rewrite may switch to a new file meanwhile, syncing the previous
+		// one before closing it
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Println("AOF fsync failed:", err)
+		}
+		a.mu.Lock()
+		a.recordFsync(latency)
+		a.fsyncStart = time.Time{}
+		a.fsyncDelayed = false
+		a.mu.Unlock()
+	}
+}
+
+// fsync syncs the file while the lock is held
+func (a *Aof) fsync(f *os.File) error {
+	start := time.Now()
+	err := f.Sync()
+	a.recordFsync(time.Since(start))
+	// Synced again by the next attempt when failed
+	a.dirty = err != nil
+	return err
 }
 
-func (a *Aof) Read() {
+func (a *Aof) recordFsync(latency time.Duration) {
+	a.fsyncLatency = latency
+	a.maxFsyncLatency = max(a.maxFsyncLatency, latency)
+}
+
+// load replaces the dataset by the content of the base file followed by the
+// commands of the incremental files. Missing incremental files are skipped,
+// see replay for corrupted ones.
+func (a *Aof) load() error {
+	keyspaceLock.Lock()
+	defer keyspaceLock.Unlock()
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	respReader := resp.NewRespReader(a.file)
+	keyspace = map[string]*redisObject{}
+	// The loaded data is not a change
+	defer func() { dirty = 0 }()
+	if base := a.manifest.base; base != nil {
+		name := filepath.Join(a.dir, base.name)
+		b, err := os.ReadFile(name)
+		if err == nil {
+			b, err = unsealFile(b)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		switch {
+		case isRdb(b):
+			err = decodeRdb(b)
+		case bytes.HasPrefix(b, []byte(snapshotMagic)):
+			err = decodeSnapshot(b)
+		default:
+			// The single AOF file of older versions is the last file as
+			// long as nothing was appended since the upgrade. Already
+			// names the file.
+			if _, err := replay(name, len(a.manifest.incrs) == 0); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for i, incr := range a.manifest.incrs {
+		name := filepath.Join(a.dir, incr.name)
+		truncated, err := replay(name, i == len(a.manifest.incrs)-1)
+		if err != nil {
+			return err
+		}
+		a.truncated = truncated
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// replay executes the commands of an AOF file. A command partially written
+// at the end of the last file, when the server stopped while writing it, is
+// truncated if aof-load-truncated is yes. Any other error is a corruption the
+// server refuses to start with, redis-lite-check-aof --fix can repair it.
+// It reports whether the file was truncated.
+func replay(name string, last bool) (bool, error) {
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		log.Printf("AOF file %s doesn't exist, skipping it", name)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	valid, err := scanAofFile(b, func(request string, params []resp.Payload) {
+		updateInMemoryStore(request, params)
+	})
+	switch {
+	case err == nil:
+		return false, nil
+	case last && errors.Is(err, io.ErrUnexpectedEOF):
+		if serverConfig.Enum("aof-load-truncated") == "yes" {
+			log.Printf("AOF file %s ends with a partial command, truncating it at offset %d", name, valid)
+			return true, os.Truncate(name, valid)
+		}
+		return false, fmt.Errorf("AOF file %s ends with a partial command at offset %d, set aof-load-truncated to yes or run redis-lite-check-aof --fix", name, valid)
+	default:
+		return false, fmt.Errorf("AOF file %s is corrupted at offset %d: %v, run redis-lite-check-aof --fix to truncate it there", name, valid, err)
+	}
+}
+
+// scanAofFile reads the commands of an AOF file, decrypting it when it is
+// encrypted, see scanAof
+func scanAofFile(b []byte, fn func(request string, params []resp.Payload)) (int64, error) {
+	if !isSealed(b) {
+		return scanAof(bytes.NewReader(b), fn)
+	}
+	return unsealRecords(b, func(record []byte) error {
+		// Records are written whole, a partial command is a corruption
+		if _, err := scanAof(bytes.NewReader(record), fn); err != nil {
+			return fmt.Errorf("bad command in record: %v", err)
+		}
+		return nil
+	})
+}
+
+// scanAof reads the commands of an AOF file, calling fn for each of them.
+// It returns the offset of the end of the last valid command along with the
+// error that stopped reading, io.ErrUnexpectedEOF when the file ends with a
+// partial command.
+func scanAof(r io.Reader, fn func(request string, params []resp.Payload)) (int64, error) {
+	counter := &countingReader{r: r}
+	respReader := resp.NewRespReader(counter)
+	// Offset of the end of the last command read
+	var valid int64
 	for {
 		cmd, err := respReader.Read()
+		if err == io.EOF {
+			return valid, nil
+		}
 		if err != nil {
-			if err != io.EOF {
-				log.Println(err)
+			return valid, err
+		}
+		if cmd.DataType != string(resp.ARRAY) || len(cmd.Array) == 0 {
+			return valid, errors.New("expected an array of bulk strings")
+		}
+		for _, arg := range cmd.Array {
+			if arg.DataType != string(resp.BULKSTRING) {
+				return valid, errors.New("expected an array of bulk strings")
 			}
-			return
 		}
 		request, params := resp.ParseRequest(&cmd)
-		updateInMemoryStore(request, params)
+		if _, ok := commands[request]; !ok {
+			return valid, fmt.Errorf("unknown command '%s'", request)
+		}
+		fn(request, params)
+		valid = counter.n - int64(respReader.Buffered())
 	}
-
 }
 
 func (a *Aof) Write(p *resp.Payload) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
 
-	_, err := a.file.Write(p.Write())
+	b := p.Write()
+	if a.sealer != nil {
+		var err error
+		if b, err = a.sealer.seal(nil, b); err != nil {
+			return err
+		}
+	}
+	a.pending = append(a.pending, b...)
+	if a.lastWriteErr = a.flush(); a.lastWriteErr != nil {
+		return a.lastWriteErr
+	}
+
+	if serverConfig.Enum("appendfsync") == "everysec" && !a.fsyncStart.IsZero() && !a.fsyncDelayed && time.Since(a.fsyncStart) > aofFsyncDelay {
+		a.fsyncDelayed = true
+		a.delayedFsyncs++
+		log.Println("Asynchronous AOF fsync is taking too long (disk is busy?)")
+	}
+	return nil
+}
+
+// flush writes the pending bytes, synced with appendfsync always since the
+// command already ran, it is durable before its reply is sent. What a short
+// write left is written by the next flush. a.mu must be held.
+func (a *Aof) flush() error {
+	n, err := a.file.Write(a.pending)
+	a.size += int64(n)
+	a.pending = a.pending[n:]
+	if n > 0 {
+		a.dirty = true
+	}
 	if err != nil {
 		return err
 	}
+	a.pending = nil
+	if a.dirty && serverConfig.Enum("appendfsync") == "always" {
+		return a.fsync(a.file)
+	}
 	return nil
 }
+
+// writeErr returns the error of the last write once trying again to write
+// what failed, write commands are refused until it succeeds
+func (a *Aof) writeErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastWriteErr != nil && a.file != nil {
+		a.lastWriteErr = a.flush()
+	}
+	return a.lastWriteErr
+}
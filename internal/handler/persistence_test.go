@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func newTestAof(t testing.TB) *Aof {
+	return openTestAof(t, t.TempDir())
+}
+
+func openTestAof(t testing.TB, dir string) *Aof {
+	aof, err := openAof(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aof.Close() })
+	return aof
+}
+
+func request(values ...string) *resp.Payload {
+	return &resp.Payload{DataType: string(resp.ARRAY), Array: args(values...)}
+}
+
+func TestAofReplayList(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("RPUSH", "list", "a", "b", "c", "d"), aof)
+	processRequest(nil, request("LPOP", "list"), aof)
+	processRequest(nil, request("LSET", "list", "0", "B"), aof)
+	processRequest(nil, request("LMOVE", "list", "other", "RIGHT", "LEFT"), aof)
+	expected := bulks(lrange(args("list", "0", "-1")))
+
+	keyspace = map[string]*redisObject{}
+	aof.load()
+
+	if got := bulks(lrange(args("list", "0", "-1"))); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after replay, got %v", expected, got)
+	}
+	if got := bulks(lrange(args("other", "0", "-1"))); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Errorf("Expected [d] after replay, got %v", got)
+	}
+}
+
+func TestAppendfsync(t *testing.T) {
+	aof := newTestAof(t)
+	aof.stop, aof.stopped = make(chan struct{}), make(chan struct{})
+	go aof.fsyncEverySecond()
+	defer serverConfig.Set("appendfsync", "everysec")
+
+	serverConfig.Set("appendfsync", "always")
+	processRequest(nil, request("SET", "key", "v"), aof)
+	if aof.dirty || aof.fsyncLatency == 0 {
+		t.Errorf("Expected the write to be synced before the reply with always")
+	}
+
+	serverConfig.Set("appendfsync", "no")
+	processRequest(nil, request("SET", "key", "v"), aof)
+	time.Sleep(1100 * time.Millisecond)
+	aof.mu.Lock()
+	if !aof.dirty {
+		t.Errorf("Expected no fsync with no")
+	}
+	aof.mu.Unlock()
+
+	serverConfig.Set("appendfsync", "everysec")
+	time.Sleep(1100 * time.Millisecond)
+	aof.mu.Lock()
+	if aof.dirty {
+		t.Errorf("Expected a fsync within a second with everysec")
+	}
+	// A write while the fsync in progress is late is reported once
+	aof.fsyncStart = time.Now().Add(-3 * time.Second)
+	aof.mu.Unlock()
+	processRequest(nil, request("SET", "key", "v"), aof)
+	processRequest(nil, request("SET", "key", "v"), aof)
+	if response := info(aof, args("persistence")); !strings.Contains(string(response.Bulk), "aof_delayed_fsync:1\r\n") {
+		t.Errorf("Expected one delayed fsync, got %q", response.Bulk)
+	}
+
+	if err := aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-aof.stopped:
+	default:
+		t.Errorf("Expected Close to stop the fsync goroutine")
+	}
+	if aof.dirty {
+		t.Errorf("Expected Close to sync the file")
+	}
+}
+
+func TestAofPropagation(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "str", "abc"), aof)
+	processRequest(nil, request("INCR", "str"), aof)
+	processRequest(nil, request("SET", "key", "v", "EX", "abc"), aof)
+	processRequest(nil, request("SADD", "set", "a", "b", "c", "d"), aof)
+	processRequest(nil, request("SADD", "set", "a"), aof)
+	processRequest(nil, request("HSET", "str", "f", "v"), aof)
+	processRequest(nil, request("SPOP", "set", "2"), aof)
+	processRequest(nil, request("HINCRBYFLOAT", "hash", "f", "0.1"), aof)
+	processRequest(nil, request("HPEXPIRE", "hash", "99999", "FIELDS", "1", "f"), aof)
+	processRequest(nil, request("HINCRBYFLOAT", "hash", "f", "0.2"), aof)
+	expected := dataset()
+
+	// Only the commands that changed the dataset are logged, the random
+	// pops and float increments as their effect, keeping the field TTL
+	var logged []string
+	aof.file.Seek(0, 0)
+	reader := resp.NewRespReader(aof.file)
+	for {
+		cmd, err := reader.Read()
+		if err != nil {
+			break
+		}
+		logged = append(logged, string(cmd.Array[0].Bulk))
+	}
+	if want := []string{"SET", "SADD", "SREM", "HSET", "HPEXPIREAT", "HSET", "HPEXPIREAT"}; !reflect.DeepEqual(logged, want) {
+		t.Errorf("Expected %v to be logged, got %v", want, logged)
+	}
+
+	keyspace = map[string]*redisObject{}
+	aof.load()
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after replay, got %v", expected, got)
+	}
+}
+
+func TestAofWriteError(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "a", "1"), aof)
+
+	// The file fails underneath
+	f := aof.file
+	f.Close()
+	if response := processRequest(nil, request("SET", "b", "1"), aof); response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected a MISCONF error when the write fails, got %v", response)
+	}
+	if response := processRequest(nil, request("SET", "c", "1"), aof); response.ErrorCode() != "MISCONF" {
+		t.Errorf("Expected writes to be refused, got %v", response)
+	}
+	if _, ok := keyspace["c"]; ok {
+		t.Errorf("Expected the refused write not to be applied")
+	}
+	if response := processRequest(nil, request("GET", "a"), aof); string(response.Bulk) != "1" {
+		t.Errorf("Expected reads to be served, got %v", response)
+	}
+	if response := info(aof, args("persistence")); !strings.Contains(string(response.Bulk), "aof_last_write_status:err\r\n") {
+		t.Errorf("Expected the write error in INFO, got %q", response.Bulk)
+	}
+
+	// The command that failed is written first once the file works again
+	f, _ = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	aof.mu.Lock()
+	aof.file = f
+	aof.mu.Unlock()
+	processRequest(nil, request("SET", "c", "1"), aof)
+	expected := dataset()
+	aof.Close()
+	keyspace = map[string]*redisObject{}
+	openTestAof(t, aof.dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after restart, got %v", expected, got)
+	}
+}
+
+func TestAofRestart(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "str", "a"), aof)
+	processRequest(nil, request("SET", "gone", "a"), aof)
+	processRequest(nil, request("DEL", "gone", "missing"), aof)
+	processRequest(nil, request("RPUSH", "list", "a", "b", "c"), aof)
+	processRequest(nil, request("LTRIM", "list", "1", "-1"), aof)
+	processRequest(nil, request("DEL", "list"), aof)
+	processRequest(nil, request("RPUSH", "list", "x"), aof)
+	processRequest(nil, request("EXPIRE", "list", "100"), aof)
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "f2", "v2"), aof)
+	processRequest(nil, request("HDEL", "hash", "f1"), aof)
+	processRequest(nil, request("HEXPIRE", "hash", "100", "FIELDS", "1", "f2"), aof)
+	processRequest(nil, request("SADD", "s1", "a", "b", "c"), aof)
+	processRequest(nil, request("SADD", "s2", "b", "c", "d"), aof)
+	processRequest(nil, request("SINTERSTORE", "inter", "s1", "s2"), aof)
+	processRequest(nil, request("DEL", "s2"), aof)
+	processRequest(nil, request("ZADD", "zset", "1", "a", "2", "b", "3", "c"), aof)
+	processRequest(nil, request("ZINCRBY", "zset", "5", "a"), aof)
+	processRequest(nil, request("ZPOPMIN", "zset"), aof)
+	processRequest(nil, request("GET", "str"), aof)
+	expected := dataset()
+
+	// Restart on the same files
+	aof.Close()
+	keyspace = map[string]*redisObject{}
+	openTestAof(t, aof.dir)
+
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after restart, got %v", expected, got)
+	}
+	for _, key := range []string{"gone", "s2"} {
+		if _, ok := keyspace[key]; ok {
+			t.Errorf("Expected %s to stay deleted after restart", key)
+		}
+	}
+}
+
+// setConfig changes a setting for the duration of the test
+func setConfig(t *testing.T, name, value string) {
+	old, _ := serverConfig.Get(name)
+	serverConfig.Set(name, value)
+	t.Cleanup(func() { serverConfig.Set(name, old) })
+}
+
+func TestAppendonly(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	setConfig(t, "dir", dir)
+	setConfig(t, "appendonly", "no")
+	setConfig(t, "save", "")
+	setConfig(t, "appendfilename", "other.aof")
+
+	// In memory, nothing is written
+	keyspace = map[string]*redisObject{}
+	aof, err := NewAof()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aof.Close() })
+	processRequest(nil, request("SET", "before", "1"), aof)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written in memory, got %v", err)
+	}
+	if response := processRequest(nil, request("CONFIG", "SET", "dir", t.TempDir()), aof); response.ErrorCode() != "ERR" {
+		t.Errorf("Expected dir to be immutable, got %v", response)
+	}
+
+	// Seeded by a rewrite when turned on
+	if response := processRequest(nil, request("CONFIG", "SET", "appendonly", "yes"), aof); response.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", response)
+	}
+	processRequest(nil, request("SET", "after", "1"), aof)
+	waitRewrite(t, aof)
+	expected := dataset()
+	if manifest, _ := os.ReadFile(filepath.Join(dir, "other.aof.manifest")); string(manifest) != "file other.aof.1.base.snap seq 1 type b\nfile other.aof.1.incr.aof seq 1 type i\n" {
+		t.Errorf("Unexpected manifest %q", manifest)
+	}
+
+	// Turned off, commands are not logged anymore
+	processRequest(nil, request("CONFIG", "SET", "appendonly", "no"), aof)
+	processRequest(nil, request("SET", "off", "1"), aof)
+	openTestAof(t, dir)
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v loading the AOF, got %v", expected, got)
+	}
+}
@@ -0,0 +1,253 @@
+package handler
+
+// Quicklist is the list representation: a doubly linked list of small
+// chunks. Pushing and popping at both ends only touches the chunk at that
+// end, and looking up an index skips whole chunks instead of elements.
+
+const quicklistNodeSize = 128
+
+type quicklistNode struct {
+	prev, next *quicklistNode
+	entries    []string
+}
+
+type quicklist struct {
+	head, tail *quicklistNode
+	length     int
+}
+
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+func (l *quicklist) len() int {
+	return l.length
+}
+
+// linkAfter inserts n after prev, or at the head when prev is nil
+func (l *quicklist) linkAfter(prev, n *quicklistNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next != nil {
+		n.next.prev = n
+	} else {
+		l.tail = n
+	}
+}
+
+// unlink removes n from the list. n keeps its prev and next pointers so that
+// iterators positioned on it can still move on.
+func (l *quicklist) unlink(n *quicklistNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+}
+
+func (l *quicklist) pushHead(v string) {
+	if l.head == nil || len(l.head.entries) >= quicklistNodeSize {
+		l.linkAfter(nil, &quicklistNode{})
+	}
+	h := l.head
+	h.entries = append(h.entries, "")
+	copy(h.entries[1:], h.entries)
+	h.entries[0] = v
+	l.length++
+}
+
+func (l *quicklist) pushTail(v string) {
+	if l.tail == nil || len(l.tail.entries) >= quicklistNodeSize {
+		l.linkAfter(l.tail, &quicklistNode{})
+	}
+	l.tail.entries = append(l.tail.entries, v)
+	l.length++
+}
+
+func (l *quicklist) popHead() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	h := l.head
+	v := h.entries[0]
+	h.entries[0] = ""
+	h.entries = h.entries[1:]
+	if len(h.entries) == 0 {
+		l.unlink(h)
+	}
+	l.length--
+	return v, true
+}
+
+func (l *quicklist) popTail() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	t := l.tail
+	last := len(t.entries) - 1
+	v := t.entries[last]
+	t.entries[last] = ""
+	t.entries = t.entries[:last]
+	if len(t.entries) == 0 {
+		l.unlink(t)
+	}
+	l.length--
+	return v, true
+}
+
+// locate returns the node and offset of the element at index, which must be
+// in the [0, length) range. The walk starts from the closest end.
+func (l *quicklist) locate(index int) (*quicklistNode, int) {
+	if index < l.length/2 {
+		n := l.head
+		for index >= len(n.entries) {
+			index -= len(n.entries)
+			n = n.next
+		}
+		return n, index
+	}
+	n := l.tail
+	index = l.length - 1 - index
+	for index >= len(n.entries) {
+		index -= len(n.entries)
+		n = n.prev
+	}
+	return n, len(n.entries) - 1 - index
+}
+
+func (l *quicklist) get(index int) string {
+	n, off := l.locate(index)
+	return n.entries[off]
+}
+
+func (l *quicklist) set(index int, v string) {
+	n, off := l.locate(index)
+	n.entries[off] = v
+}
+
+// insertAt inserts v at offset off of node n, splitting the node in two when
+// it is full.
+func (l *quicklist) insertAt(n *quicklistNode, off int, v string) {
+	if len(n.entries) >= quicklistNodeSize {
+		half := len(n.entries) / 2
+		split := &quicklistNode{entries: append([]string(nil), n.entries[half:]...)}
+		clear(n.entries[half:])
+		n.entries = n.entries[:half]
+		l.linkAfter(n, split)
+		if off > half {
+			n, off = split, off-half
+		}
+	}
+	n.entries = append(n.entries, "")
+	copy(n.entries[off+1:], n.entries[off:])
+	n.entries[off] = v
+	l.length++
+}
+
+// deleteAt removes the element at offset off of node n. It returns true when
+// the node became empty and was unlinked.
+func (l *quicklist) deleteAt(n *quicklistNode, off int) bool {
+	copy(n.entries[off:], n.entries[off+1:])
+	n.entries[len(n.entries)-1] = ""
+	n.entries = n.entries[:len(n.entries)-1]
+	l.length--
+	if len(n.entries) == 0 {
+		l.unlink(n)
+		return true
+	}
+	return false
+}
+
+type quicklistIter struct {
+	list     *quicklist
+	node     *quicklistNode
+	offset   int
+	reverse  bool
+	lastNode *quicklistNode
+	lastOff  int
+}
+
+// iterator returns an iterator starting at index, walking towards the tail,
+// or towards the head when reverse is set.
+func (l *quicklist) iterator(index int, reverse bool) *quicklistIter {
+	it := &quicklistIter{list: l, reverse: reverse}
+	if index >= 0 && index < l.length {
+		it.node, it.offset = l.locate(index)
+	}
+	return it
+}
+
+func (it *quicklistIter) next() (string, bool) {
+	for it.node != nil {
+		if it.reverse && it.offset < 0 {
+			it.node = it.node.prev
+			if it.node != nil {
+				it.offset = len(it.node.entries) - 1
+			}
+			continue
+		}
+		if !it.reverse && it.offset >= len(it.node.entries) {
+			it.node = it.node.next
+			it.offset = 0
+			continue
+		}
+		it.lastNode, it.lastOff = it.node, it.offset
+		v := it.node.entries[it.offset]
+		if it.reverse {
+			it.offset--
+		} else {
+			it.offset++
+		}
+		return v, true
+	}
+	return "", false
+}
+
+// remove deletes the element returned by the last call to next
+func (it *quicklistIter) remove() {
+	unlinked := it.list.deleteAt(it.lastNode, it.lastOff)
+	if it.reverse {
+		// Elements before the removed one did not move
+		return
+	}
+	if unlinked {
+		it.node, it.offset = it.lastNode.next, 0
+	} else {
+		it.offset = it.lastOff
+	}
+}
+
+// insert adds v right before or after the element returned by the last call
+// to next
+func (it *quicklistIter) insert(v string, after bool) {
+	off := it.lastOff
+	if after {
+		off++
+	}
+	it.list.insertAt(it.lastNode, off, v)
+}
+
+// slice returns count elements starting at index
+func (l *quicklist) slice(index, count int) []string {
+	values := make([]string, 0, count)
+	it := l.iterator(index, false)
+	for len(values) < count {
+		v, ok := it.next()
+		if !ok {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+)
+
+func quicklistValues(l *quicklist) []string {
+	return l.slice(0, l.len())
+}
+
+func TestQuicklistPushPop(t *testing.T) {
+	l := newQuicklist()
+	n := quicklistNodeSize*3 + 5
+	for i := 0; i < n; i++ {
+		l.pushTail(strconv.Itoa(i))
+		l.pushHead(strconv.Itoa(-i))
+	}
+	if l.len() != 2*n {
+		t.Fatalf("Expected %d elements, got %d", 2*n, l.len())
+	}
+	if v := l.get(0); v != strconv.Itoa(-(n - 1)) {
+		t.Errorf("Expected %d at head, got %s", -(n - 1), v)
+	}
+	if v := l.get(l.len() - 1); v != strconv.Itoa(n-1) {
+		t.Errorf("Expected %d at tail, got %s", n-1, v)
+	}
+	for i := n - 1; i >= 0; i-- {
+		if v, _ := l.popTail(); v != strconv.Itoa(i) {
+			t.Fatalf("Expected %d, got %s", i, v)
+		}
+		if v, _ := l.popHead(); v != strconv.Itoa(-i) {
+			t.Fatalf("Expected %d, got %s", -i, v)
+		}
+	}
+	if _, ok := l.popHead(); ok || l.head != nil || l.tail != nil {
+		t.Errorf("Expected empty list")
+	}
+}
+
+func TestQuicklistIterator(t *testing.T) {
+	l := newQuicklist()
+	for i := 0; i < quicklistNodeSize*2; i++ {
+		l.pushTail(strconv.Itoa(i % 3))
+	}
+
+	// Remove every "0" while iterating forward across node boundaries
+	it := l.iterator(0, false)
+	for {
+		v, ok := it.next()
+		if !ok {
+			break
+		}
+		if v == "0" {
+			it.remove()
+		}
+	}
+	for _, v := range quicklistValues(l) {
+		if v == "0" {
+			t.Fatalf("Expected all 0 to be removed")
+		}
+	}
+
+	// Inserting in a full node splits it
+	l = newQuicklist()
+	for i := 0; i < quicklistNodeSize; i++ {
+		l.pushTail(strconv.Itoa(i))
+	}
+	it = l.iterator(quicklistNodeSize-1, true)
+	it.next()
+	it.insert("x", false)
+	if l.get(quicklistNodeSize-1) != "x" || l.get(quicklistNodeSize) != strconv.Itoa(quicklistNodeSize-1) {
+		t.Errorf("Unexpected list after insert %v", quicklistValues(l))
+	}
+	if l.head == l.tail {
+		t.Errorf("Expected node to be split")
+	}
+}
@@ -0,0 +1,930 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Redis RDB files, to load a dump.rdb taken from Redis and give one back.
+// Versions 9 to 11 are read, with the compact encodings Redis uses for small
+// values (ziplists, listpacks and intsets) and LZF compressed strings.
+// Files are written in version 11 with the plain encodings, which any Redis
+// since 7.2 loads. Hash field expirations can not be represented before
+// version 12 and are dropped.
+
+const (
+	rdbMinVersion = 9
+	rdbVersion    = 11
+)
+
+// Value types
+const (
+	rdbTypeString         = 0
+	rdbTypeList           = 1
+	rdbTypeSet            = 2
+	rdbTypeZset           = 3
+	rdbTypeHash           = 4
+	rdbTypeZset2          = 5
+	rdbTypeHashZiplist    = 13
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZsetZiplist    = 12
+	rdbTypeListQuicklist  = 14
+	rdbTypeHashListpack   = 16
+	rdbTypeZsetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20
+)
+
+// Opcodes
+const (
+	rdbOpFunction2    = 0xf6
+	rdbOpFreq         = 0xf7
+	rdbOpIdle         = 0xf8
+	rdbOpModuleAux    = 0xf9
+	rdbOpAux          = 0xfa
+	rdbOpResizeDB     = 0xfb
+	rdbOpExpireTimeMs = 0xfc
+	rdbOpExpireTime   = 0xfd
+	rdbOpSelectDB     = 0xfe
+	rdbOpEOF          = 0xff
+)
+
+// Length encodings, told apart by the 2 high bits of the first byte
+const (
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLzf   = 3
+)
+
+// Quicklist node containers
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// Redis checksums with the Jones polynomial, reflected, with no initial or
+// final xor unlike hash/crc64 which is worked around by inverting the crc
+var rdbCrcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func rdbChecksum(b []byte) uint64 {
+	return ^crc64.Update(^uint64(0), rdbCrcTable, b)
+}
+
+var errRdbCorrupted = errors.New("RDB file is corrupted")
+
+type rdbEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *rdbEncoder) len(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.buf.WriteByte(byte(n))
+	case n < 1<<14:
+		e.buf.WriteByte(byte(n>>8) | rdb14BitLen<<6)
+		e.buf.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		e.buf.WriteByte(rdb32BitLen)
+		e.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		e.buf.WriteByte(rdb64BitLen)
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+// string writes s as an integer when it is one that fits in 32 bits, and LZF
+// compressed when it is long enough to gain from it as Redis does
+func (e *rdbEncoder) string(s string) {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(v, 10) == s {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				e.buf.Write([]byte{rdbEncVal<<6 | rdbEncInt8, byte(v)})
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				e.buf.WriteByte(rdbEncVal<<6 | rdbEncInt16)
+				e.buf.Write(binary.LittleEndian.AppendUint16(nil, uint16(v)))
+			default:
+				e.buf.WriteByte(rdbEncVal<<6 | rdbEncInt32)
+				e.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+			}
+			return
+		}
+	}
+	if len(s) > 20 {
+		if c := lzfCompress([]byte(s)); len(c) < len(s)-4 {
+			e.buf.WriteByte(rdbEncVal<<6 | rdbEncLzf)
+			e.len(uint64(len(c)))
+			e.len(uint64(len(s)))
+			e.buf.Write(c)
+			return
+		}
+	}
+	e.len(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *rdbEncoder) object(key string, obj *redisObject, now time.Time) {
+	if !obj.expire.IsZero() {
+		e.buf.WriteByte(rdbOpExpireTimeMs)
+		e.buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(obj.expire.UnixMilli())))
+	}
+	switch obj.kind {
+	case stringType:
+		e.buf.WriteByte(rdbTypeString)
+		e.string(key)
+		e.string(obj.value.(string))
+	case listType:
+		l := obj.value.(*quicklist)
+		e.buf.WriteByte(rdbTypeList)
+		e.string(key)
+		e.len(uint64(l.len()))
+		it := l.iterator(0, false)
+		for v, ok := it.next(); ok; v, ok = it.next() {
+			e.string(v)
+		}
+	case setType:
+		s := obj.value.(*redisSet)
+		if s.dict == nil {
+			e.buf.WriteByte(rdbTypeSetIntset)
+			e.string(key)
+			e.string(string(encodeIntset(s.intset)))
+			break
+		}
+		e.buf.WriteByte(rdbTypeSet)
+		e.string(key)
+		e.len(uint64(len(s.dict)))
+		for member := range s.dict {
+			e.string(member)
+		}
+	case zsetType:
+		z := obj.value.(*sortedSet)
+		e.buf.WriteByte(rdbTypeZset2)
+		e.string(key)
+		e.len(uint64(z.len()))
+		for n := z.zsl.header.level[0].forward; n != nil; n = n.level[0].forward {
+			e.string(n.member)
+			e.buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(n.score)))
+		}
+	case hashType:
+		h := obj.value.(*redisHash)
+		var items []string
+		for f, v := range h.fields {
+			if v.expire.IsZero() || v.expire.After(now) {
+				items = append(items, f, v.value)
+			}
+		}
+		e.buf.WriteByte(rdbTypeHash)
+		e.string(key)
+		e.len(uint64(len(items) / 2))
+		for _, item := range items {
+			e.string(item)
+		}
+	}
+}
+
+// encodeIntset returns the intset of Redis holding values, sorted
+func encodeIntset(values []int64) []byte {
+	width := 2
+	for _, v := range values {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			width = 8
+		} else if (v < math.MinInt16 || v > math.MaxInt16) && width < 4 {
+			width = 4
+		}
+	}
+	b := binary.LittleEndian.AppendUint32(nil, uint32(width))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(values)))
+	for _, v := range values {
+		switch width {
+		case 2:
+			b = binary.LittleEndian.AppendUint16(b, uint16(v))
+		case 4:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		default:
+			b = binary.LittleEndian.AppendUint64(b, uint64(v))
+		}
+	}
+	return b
+}
+
+// encodeRdb returns the content of an RDB file holding the dataset,
+// keyspaceLock must be held
+func encodeRdb() []byte {
+	now := time.Now()
+	e := &rdbEncoder{}
+	e.buf.WriteString(fmt.Sprintf("REDIS%04d", rdbVersion))
+	for _, aux := range [][2]string{
+		{"redis-ver", redisVersion},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(now.Unix(), 10)},
+		{"aof-base", "0"},
+	} {
+		e.buf.WriteByte(rdbOpAux)
+		e.string(aux[0])
+		e.string(aux[1])
+	}
+
+	live := func(obj *redisObject) bool {
+		if obj.isExpired(now) {
+			return false
+		}
+		// Redis refuses empty keys, hashes may only hold expired fields
+		if obj.kind == hashType {
+			for _, v := range obj.value.(*redisHash).fields {
+				if v.expire.IsZero() || v.expire.After(now) {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}
+	var keys, expires int
+	for _, obj := range keyspace {
+		if live(obj) {
+			keys++
+			if !obj.expire.IsZero() {
+				expires++
+			}
+		}
+	}
+	e.buf.WriteByte(rdbOpSelectDB)
+	e.len(0)
+	e.buf.WriteByte(rdbOpResizeDB)
+	e.len(uint64(keys))
+	e.len(uint64(expires))
+	for key, obj := range keyspace {
+		if live(obj) {
+			e.object(key, obj, now)
+		}
+	}
+	e.buf.WriteByte(rdbOpEOF)
+	return binary.LittleEndian.AppendUint64(e.buf.Bytes(), rdbChecksum(e.buf.Bytes()))
+}
+
+type rdbDecoder struct {
+	b []byte
+}
+
+func (d *rdbDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.b) {
+		return nil, errRdbCorrupted
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *rdbDecoder) byte() (byte, error) {
+	b, err := d.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// len reads a length, or the kind of a specially encoded string when
+// encoded is set
+func (d *rdbDecoder) len() (n uint64, encoded bool, err error) {
+	c, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case c>>6 == rdb6BitLen:
+		return uint64(c & 0x3f), false, nil
+	case c>>6 == rdb14BitLen:
+		next, err := d.byte()
+		return uint64(c&0x3f)<<8 | uint64(next), false, err
+	case c>>6 == rdbEncVal:
+		return uint64(c & 0x3f), true, nil
+	case c == rdb32BitLen:
+		b, err := d.bytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	case c == rdb64BitLen:
+		b, err := d.bytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+	return 0, false, errRdbCorrupted
+}
+
+// count reads a number of items, each taking at least one byte
+func (d *rdbDecoder) count() (int, error) {
+	n, encoded, err := d.len()
+	if err == nil && (encoded || n > uint64(len(d.b))) {
+		err = errRdbCorrupted
+	}
+	return int(n), err
+}
+
+func (d *rdbDecoder) string() (string, error) {
+	n, encoded, err := d.len()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > uint64(len(d.b)) {
+			return "", errRdbCorrupted
+		}
+		b, err := d.bytes(int(n))
+		return string(b), err
+	}
+
+	switch n {
+	case rdbEncInt8:
+		b, err := d.bytes(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case rdbEncInt16:
+		b, err := d.bytes(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case rdbEncInt32:
+		b, err := d.bytes(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case rdbEncLzf:
+		clen, err := d.count()
+		if err != nil {
+			return "", err
+		}
+		// Beyond what LZF can expand clen bytes to
+		ulen, _, err := d.len()
+		if err != nil || ulen > uint64(clen)*(lzfMaxMatch/2) {
+			return "", errRdbCorrupted
+		}
+		c, err := d.bytes(clen)
+		if err != nil {
+			return "", err
+		}
+		b, err := lzfDecompress(c, int(ulen))
+		return string(b), err
+	}
+	return "", fmt.Errorf("unknown RDB string encoding %d", n)
+}
+
+// zsetScore reads the score of the old zset type, a string with special
+// lengths for nan and infinities
+func (d *rdbDecoder) zsetScore() (float64, error) {
+	n, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.bytes(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// strings reads n strings
+func (d *rdbDecoder) strings(n int) ([]string, error) {
+	values := make([]string, n)
+	for i := range values {
+		var err error
+		if values[i], err = d.string(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// value reads the value of a key of the given type
+func (d *rdbDecoder) value(kind byte) (*redisObject, error) {
+	switch kind {
+	case rdbTypeString:
+		s, err := d.string()
+		return &redisObject{kind: stringType, value: s}, err
+
+	case rdbTypeList, rdbTypeSet, rdbTypeHash:
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		if kind == rdbTypeHash {
+			n *= 2
+		}
+		values, err := d.strings(n)
+		if err != nil {
+			return nil, err
+		}
+		return newObject(kind, values)
+
+	case rdbTypeZset, rdbTypeZset2:
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		z := newSortedSet()
+		for i := 0; i < n; i++ {
+			member, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if kind == rdbTypeZset {
+				score, err = d.zsetScore()
+			} else {
+				var b []byte
+				b, err = d.bytes(8)
+				if err == nil {
+					score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			z.remove(member)
+			z.insert(score, member)
+		}
+		return &redisObject{kind: zsetType, value: z}, nil
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for i := 0; i < n; i++ {
+			container := uint64(quicklistNodePacked)
+			if kind == rdbTypeListQuicklist2 {
+				if container, _, err = d.len(); err != nil {
+					return nil, err
+				}
+			}
+			node, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			var entries []string
+			switch {
+			case container == quicklistNodePlain:
+				entries = []string{node}
+			case kind == rdbTypeListQuicklist:
+				entries, err = decodeZiplist([]byte(node))
+			default:
+				entries, err = decodeListpack([]byte(node))
+			}
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, entries...)
+		}
+		return newObject(rdbTypeList, values)
+
+	case rdbTypeSetIntset, rdbTypeListZiplist, rdbTypeZsetZiplist, rdbTypeHashZiplist,
+		rdbTypeSetListpack, rdbTypeZsetListpack, rdbTypeHashListpack:
+		blob, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		switch kind {
+		case rdbTypeSetIntset:
+			values, err = decodeIntset([]byte(blob))
+		case rdbTypeListZiplist, rdbTypeZsetZiplist, rdbTypeHashZiplist:
+			values, err = decodeZiplist([]byte(blob))
+		default:
+			values, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case rdbTypeSetIntset, rdbTypeSetListpack:
+			return newObject(rdbTypeSet, values)
+		case rdbTypeListZiplist:
+			return newObject(rdbTypeList, values)
+		case rdbTypeHashZiplist, rdbTypeHashListpack:
+			return newObject(rdbTypeHash, values)
+		}
+		if len(values)%2 != 0 {
+			return nil, errRdbCorrupted
+		}
+		z := newSortedSet()
+		for i := 0; i < len(values); i += 2 {
+			score, err := strconv.ParseFloat(values[i+1], 64)
+			if err != nil {
+				return nil, errRdbCorrupted
+			}
+			z.remove(values[i])
+			z.insert(score, values[i])
+		}
+		return &redisObject{kind: zsetType, value: z}, nil
+	}
+	return nil, fmt.Errorf("unsupported RDB value type %d", kind)
+}
+
+// newObject builds a list, a set or a hash from its elements, hashes being
+// given as interleaved fields and values
+func newObject(kind byte, values []string) (*redisObject, error) {
+	switch kind {
+	case rdbTypeList:
+		l := newQuicklist()
+		for _, v := range values {
+			l.pushTail(v)
+		}
+		return &redisObject{kind: listType, value: l}, nil
+	case rdbTypeSet:
+		s := newSet()
+		for _, v := range values {
+			s.add(v)
+		}
+		return &redisObject{kind: setType, value: s}, nil
+	default:
+		if len(values)%2 != 0 {
+			return nil, errRdbCorrupted
+		}
+		h := newHash()
+		for i := 0; i < len(values); i += 2 {
+			h.set(values[i], values[i+1])
+		}
+		return &redisObject{kind: hashType, value: h}, nil
+	}
+}
+
+// decodeIntset returns the integers of an intset as strings
+func decodeIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errRdbCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	b = b[8:]
+	if (width != 2 && width != 4 && width != 8) || n*width != len(b) {
+		return nil, errRdbCorrupted
+	}
+	values := make([]string, n)
+	for i := range values {
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(b[i*2:])))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(b[i*4:])))
+		default:
+			v = int64(binary.LittleEndian.Uint64(b[i*8:]))
+		}
+		values[i] = strconv.FormatInt(v, 10)
+	}
+	return values, nil
+}
+
+// int24 reads a signed little endian 24 bits integer
+func int24(b []byte) int64 {
+	return int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+}
+
+// decodeZiplist returns the entries of a ziplist, the encoding of small
+// lists, hashes and sorted sets before Redis 7:
+//
+//	<zlbytes:4> <zltail:4> <zllen:2> <entry> ... 0xff
+//	entry: <prevlen:1 or 0xfe + 4> <encoding> <data>
+func decodeZiplist(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, errRdbCorrupted
+	}
+	var values []string
+	i := 10
+	for i < len(b) && b[i] != 0xff {
+		if b[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errRdbCorrupted
+		}
+		enc := b[i]
+		var size, header int
+		var value string
+		var integer bool
+		switch enc >> 6 {
+		case 0:
+			header, size = 1, int(enc&0x3f)
+		case 1:
+			if i+1 >= len(b) {
+				return nil, errRdbCorrupted
+			}
+			header, size = 2, int(enc&0x3f)<<8|int(b[i+1])
+		case 2:
+			if i+4 >= len(b) {
+				return nil, errRdbCorrupted
+			}
+			header, size = 5, int(binary.BigEndian.Uint32(b[i+1:]))
+		default:
+			header, integer = 1, true
+			switch enc {
+			case 0xc0:
+				size = 2
+			case 0xd0:
+				size = 4
+			case 0xe0:
+				size = 8
+			case 0xf0:
+				size = 3
+			case 0xfe:
+				size = 1
+			default:
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, errRdbCorrupted
+				}
+				value = strconv.Itoa(int(enc&0x0f) - 1)
+			}
+		}
+		start := i + header
+		if size < 0 || start+size > len(b) {
+			return nil, errRdbCorrupted
+		}
+		data := b[start : start+size]
+		switch {
+		case !integer:
+			value = string(data)
+		case size == 1:
+			value = strconv.Itoa(int(int8(data[0])))
+		case size == 2:
+			value = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(data))))
+		case size == 3:
+			value = strconv.FormatInt(int24(data), 10)
+		case size == 4:
+			value = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(data))))
+		case size == 8:
+			value = strconv.FormatInt(int64(binary.LittleEndian.Uint64(data)), 10)
+		}
+		values = append(values, value)
+		i = start + size
+	}
+	if i >= len(b) {
+		return nil, errRdbCorrupted
+	}
+	return values, nil
+}
+
+// decodeListpack returns the entries of a listpack, the encoding of small
+// lists, hashes, sets and sorted sets since Redis 7:
+//
+//	<total bytes:4> <count:2> <entry> ... 0xff
+//	entry: <encoding> <data> <backlen>
+//
+// backlen is the size of the encoding and the data, on 1 to 5 bytes
+func decodeListpack(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, errRdbCorrupted
+	}
+	var values []string
+	i := 6
+	for i < len(b) && b[i] != 0xff {
+		enc := b[i]
+		var header, size int
+		var value string
+		var integer, str bool
+		need := func(n int) bool { return i+n <= len(b) }
+		switch {
+		case enc&0x80 == 0:
+			header, value = 1, strconv.Itoa(int(enc))
+		case enc&0xc0 == 0x80:
+			header, size, str = 1, int(enc&0x3f), true
+		case enc&0xe0 == 0xc0:
+			if !need(2) {
+				return nil, errRdbCorrupted
+			}
+			v := int(enc&0x1f)<<8 | int(b[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			header, value = 2, strconv.Itoa(v)
+		case enc&0xf0 == 0xe0:
+			if !need(2) {
+				return nil, errRdbCorrupted
+			}
+			header, size, str = 2, int(enc&0x0f)<<8|int(b[i+1]), true
+		case enc == 0xf0:
+			if !need(5) {
+				return nil, errRdbCorrupted
+			}
+			header, size, str = 5, int(binary.LittleEndian.Uint32(b[i+1:])), true
+		case enc >= 0xf1 && enc <= 0xf4:
+			header, integer = 1, true
+			size = map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+		default:
+			return nil, errRdbCorrupted
+		}
+		start := i + header
+		if size < 0 || start+size > len(b) {
+			return nil, errRdbCorrupted
+		}
+		data := b[start : start+size]
+		switch {
+		case str:
+			value = string(data)
+		case integer && size == 2:
+			value = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(data))))
+		case integer && size == 3:
+			value = strconv.FormatInt(int24(data), 10)
+		case integer && size == 4:
+			value = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(data))))
+		case integer:
+			value = strconv.FormatInt(int64(binary.LittleEndian.Uint64(data)), 10)
+		}
+		values = append(values, value)
+		i = start + size + listpackBacklenSize(header+size)
+	}
+	if i >= len(b) {
+		return nil, errRdbCorrupted
+	}
+	return values, nil
+}
+
+// listpackBacklenSize returns the size of the backlen of an entry of l bytes
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// decodeRdb replaces the dataset by the content of an RDB file. Keys already
+// expired are skipped, as are the keys of databases other than 0 since there
+// is a single one. keyspaceLock must be held.
+func decodeRdb(b []byte) error {
+	if len(b) < 9+1 || string(b[:5]) != "REDIS" {
+		return errors.New("not an RDB file")
+	}
+	version, err := strconv.Atoi(string(b[5:9]))
+	if err != nil || version < rdbMinVersion || version > rdbVersion {
+		return fmt.Errorf("can't handle RDB format version %s", b[5:9])
+	}
+
+	now := time.Now()
+	keyspace = map[string]*redisObject{}
+	d := &rdbDecoder{b: b[9:]}
+	var expire time.Time
+	db, skipped := 0, 0
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case rdbOpEOF:
+			if len(d.b) != 8 {
+				return errRdbCorrupted
+			}
+			// A zero checksum means that checksums were disabled
+			crc := binary.LittleEndian.Uint64(d.b)
+			if crc != 0 && crc != rdbChecksum(b[:len(b)-8]) {
+				return errors.New("wrong RDB checksum")
+			}
+			if skipped > 0 {
+				log.Printf("Skipped %d keys of databases other than 0", skipped)
+			}
+			return nil
+		case rdbOpSelectDB:
+			n, _, err := d.len()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+			continue
+		case rdbOpResizeDB:
+			if _, _, err := d.len(); err != nil {
+				return err
+			}
+			if _, _, err := d.len(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpAux:
+			if _, err := d.strings(2); err != nil {
+				return err
+			}
+			continue
+		case rdbOpFunction2:
+			if _, err := d.string(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpExpireTimeMs:
+			t, err := d.bytes(8)
+			if err != nil {
+				return err
+			}
+			expire = time.UnixMilli(int64(binary.LittleEndian.Uint64(t)))
+			continue
+		case rdbOpExpireTime:
+			t, err := d.bytes(4)
+			if err != nil {
+				return err
+			}
+			expire = time.Unix(int64(binary.LittleEndian.Uint32(t)), 0)
+			continue
+		case rdbOpIdle:
+			if _, _, err := d.len(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpFreq:
+			if _, err := d.byte(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpModuleAux:
+			return errors.New("RDB files with module data are not supported")
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return err
+		}
+		obj, err := d.value(op)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+		obj.expire, expire = expire, time.Time{}
+		if db != 0 {
+			skipped++
+			continue
+		}
+		if obj.isExpired(now) {
+			continue
+		}
+		keyspace[key] = obj
+		if !obj.expire.IsZero() {
+			volatileKeys[key] = struct{}{}
+		}
+	}
+}
+
+// isRdb reports whether b is the content of an RDB file rather than a
+// snapshot
+func isRdb(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("REDIS")) && !bytes.HasPrefix(b, []byte(snapshotMagic))
+}
+
+// ConvertDump converts an RDB file to a snapshot, or a snapshot to an RDB
+// file, as told by the content of the input file
+func ConvertDump(input, output string) (string, error) {
+	b, err := os.ReadFile(input)
+	if err == nil {
+		b, err = unsealFile(b)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", input, err)
+	}
+	keyspaceLock.Lock()
+	defer keyspaceLock.Unlock()
+	decode, encode, conversion := decodeSnapshot, encodeRdb, "snapshot to RDB"
+	if isRdb(b) {
+		decode, encode, conversion = decodeRdb, encodeSnapshot, "RDB to snapshot"
+	}
+	if err := decode(b); err != nil {
+		return "", fmt.Errorf("%s: %w", input, err)
+	}
+	if err := writeSnapshot(encode(), output); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Converted %d keys from %s", len(keyspace), conversion), nil
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRdbChecksum(t *testing.T) {
+	// Check value of the crc64 of Redis
+	if crc := rdbChecksum([]byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Unexpected checksum %x", crc)
+	}
+}
+
+func TestLzf(t *testing.T) {
+	// A literal a, then 9 bytes copied from 1 byte back
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xe0, 0x00, 0x00}, 10)
+	if err != nil || string(out) != "aaaaaaaaaa" {
+		t.Errorf("Expected aaaaaaaaaa, got %q, %v", out, err)
+	}
+	if _, err := lzfDecompress([]byte{0x00, 'a', 0xe0, 0x00, 0x05}, 10); err == nil {
+		t.Errorf("Expected an error on a reference before the start")
+	}
+
+	random := make([]byte, 5000)
+	rand.Read(random)
+	for _, in := range [][]byte{
+		[]byte("abc"),
+		[]byte(strings.Repeat("hello world ", 500)),
+		random,
+		append(random[:100:100], bytes.Repeat([]byte{'x'}, 1000)...),
+	} {
+		c := lzfCompress(in)
+		out, err := lzfDecompress(c, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("Round trip of %d bytes failed: %v", len(in), err)
+		}
+	}
+	if c := lzfCompress([]byte(strings.Repeat("hello world ", 500))); len(c) > 200 {
+		t.Errorf("Expected repeated data to compress, got %d bytes", len(c))
+	}
+}
+
+// Helpers building RDB files by hand, for strings shorter than 64 bytes
+func rdbStr(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func rdbKey(kind byte, key string, value ...[]byte) []byte {
+	b := append([]byte{kind}, rdbStr(key)...)
+	for _, v := range value {
+		b = append(b, v...)
+	}
+	return b
+}
+
+func listpack(entries ...[]byte) []byte {
+	body := bytes.Join(entries, nil)
+	b := binary.LittleEndian.AppendUint32(nil, uint32(6+len(body)+1))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(entries)))
+	return append(append(b, body...), 0xff)
+}
+
+func ziplist(entries ...[]byte) []byte {
+	body := bytes.Join(entries, nil)
+	b := binary.LittleEndian.AppendUint32(nil, uint32(10+len(body)+1))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(entries)))
+	return append(append(b, body...), 0xff)
+}
+
+func rdbFile(version string, body ...[]byte) []byte {
+	b := append([]byte("REDIS"+version), bytes.Join(body, nil)...)
+	b = append(b, rdbOpEOF)
+	return binary.LittleEndian.AppendUint64(b, rdbChecksum(b))
+}
+
+func TestDecodeRdb(t *testing.T) {
+	aof := newTestAof(t)
+	ttl := time.Now().Add(time.Hour).UnixMilli()
+
+	hash := listpack(
+		[]byte{0x82, 'f', '1', 0x03}, []byte{0x82, 'v', '1', 0x03},
+		[]byte{0x81, 'n', 0x02}, []byte{0x05, 0x01}, // 7 bits integer
+		[]byte{0x83, 'n', 'e', 'g', 0x04}, []byte{0xdf, 0x9c, 0x02}, // 13 bits integer
+		[]byte{0x83, 'b', 'i', 'g', 0x04}, []byte{0xf2, 0xa0, 0x86, 0x01, 0x04}, // 24 bits integer
+	)
+	zset := ziplist(
+		[]byte{0x00, 0x01, 'a'}, []byte{0x03, 0xf3}, // immediate integer
+		[]byte{0x02, 0x01, 'b'}, []byte{0x03, 0x03, '1', '.', '5'},
+	)
+	list := ziplist(
+		[]byte{0x00, 0x01, 'x'},
+		[]byte{0x03, 0xc0, 0xe8, 0x03},       // 16 bits integer
+		[]byte{0x04, 0xf0, 0xfe, 0xff, 0xff}, // 24 bits integer
+	)
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0xff, 0xff, 2, 0, 0x2c, 0x01}
+	expire := binary.LittleEndian.AppendUint64([]byte{rdbOpExpireTimeMs}, uint64(ttl))
+
+	b := rdbFile("0011",
+		[]byte{rdbOpAux}, rdbStr("redis-ver"), rdbStr("7.2.4"),
+		[]byte{rdbOpAux}, rdbStr("redis-bits"), []byte{0xc0, 64},
+		[]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 10, 1},
+		rdbKey(rdbTypeString, "str", rdbStr("value")),
+		rdbKey(rdbTypeString, "int", []byte{0xc1, 0x39, 0x30}),
+		rdbKey(rdbTypeString, "neg", []byte{0xc0, 0xff}),
+		rdbKey(rdbTypeString, "lzf", []byte{0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00}),
+		expire, rdbKey(rdbTypeString, "ttl", rdbStr("v")),
+		[]byte{rdbOpExpireTime, 1, 0, 0, 0}, rdbKey(rdbTypeString, "expired", rdbStr("v")),
+		[]byte{rdbOpIdle, 10, rdbOpFreq, 3},
+		rdbKey(rdbTypeHashListpack, "hash", rdbStr(string(hash))),
+		rdbKey(rdbTypeZsetZiplist, "zset", rdbStr(string(zset))),
+		rdbKey(rdbTypeZset, "oldzset", []byte{2}, rdbStr("m1"), rdbStr("2.5"), rdbStr("m2"), []byte{254}),
+		rdbKey(rdbTypeSetIntset, "intset", rdbStr(string(intset))),
+		rdbKey(rdbTypeSetListpack, "set", rdbStr(string(listpack([]byte{0x81, 'a', 0x02}, []byte{0x81, 'b', 0x02})))),
+		rdbKey(rdbTypeListQuicklist, "ziplist", []byte{1}, rdbStr(string(list))),
+		rdbKey(rdbTypeListQuicklist2, "list", []byte{2},
+			[]byte{quicklistNodePacked}, rdbStr(string(listpack([]byte{0x81, 'x', 0x02}, []byte{0x81, 'y', 0x02}))),
+			[]byte{quicklistNodePlain}, rdbStr("plain")),
+		[]byte{rdbOpSelectDB, 1},
+		rdbKey(rdbTypeString, "other", rdbStr("db1")),
+	)
+
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "str", "value"), aof)
+	processRequest(nil, request("SET", "int", "12345"), aof)
+	processRequest(nil, request("SET", "neg", "-1"), aof)
+	processRequest(nil, request("SET", "lzf", "aaaaaaaaaa"), aof)
+	processRequest(nil, request("SET", "ttl", "v", "PXAT", strconv.FormatInt(ttl, 10)), aof)
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "n", "5", "neg", "-100", "big", "100000"), aof)
+	processRequest(nil, request("ZADD", "zset", "2", "a", "1.5", "b"), aof)
+	processRequest(nil, request("ZADD", "oldzset", "2.5", "m1", "+inf", "m2"), aof)
+	processRequest(nil, request("SADD", "intset", "-1", "2", "300"), aof)
+	processRequest(nil, request("SADD", "set", "a", "b"), aof)
+	processRequest(nil, request("RPUSH", "ziplist", "x", "1000", "-2"), aof)
+	processRequest(nil, request("RPUSH", "list", "x", "y", "plain"), aof)
+	expected := dataset()
+
+	keyspace = map[string]*redisObject{}
+	if err := decodeRdb(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	b[len(b)-20] ^= 1
+	if err := decodeRdb(b); err == nil {
+		t.Errorf("Expected an error on a corrupted file")
+	}
+	lzfBomb := []byte{0xc3, 5, 0x80, 0x40, 0, 0, 0, 0x00, 'a', 0xe0, 0x00, 0x00}
+	if err := decodeRdb(rdbFile("0011", rdbKey(rdbTypeString, "lzf", lzfBomb))); !errors.Is(err, errRdbCorrupted) {
+		t.Errorf("Expected an LZF length beyond its expansion to be refused, got %v", err)
+	}
+	if err := decodeRdb(rdbFile("0012")); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected RDB version 12 to be refused, got %v", err)
+	}
+	if err := decodeRdb(rdbFile("0009", rdbKey(15, "stream", rdbStr("")))); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("Expected streams to be refused, got %v", err)
+	}
+}
+
+func TestRdbRoundTrip(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	for i := 0; i < 1000; i++ {
+		processRequest(nil, request("RPUSH", "list", strconv.Itoa(i*1000)), aof)
+	}
+	processRequest(nil, request("SET", "compressed", strings.Repeat("abcd", 100)), aof)
+	processRequest(nil, request("SET", "long", strings.Repeat("x", 20000)), aof)
+	processRequest(nil, request("SET", "int", "-2147483648"), aof)
+	processRequest(nil, request("SET", "notint", "0123"), aof)
+	processRequest(nil, request("SADD", "intset", "-70000", "1", "5000000000"), aof)
+	processRequest(nil, request("SADD", "set", "a", "b"), aof)
+	processRequest(nil, request("ZADD", "zset", "1.5", "a", "-inf", "b", "2e30", "c"), aof)
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "f2", "v2"), aof)
+	processRequest(nil, request("SET", "volatile", "v", "EX", "100"), aof)
+	processRequest(nil, request("HSET", "fields", "f1", "v1", "f2", "v2"), aof)
+	processRequest(nil, request("HPEXPIRE", "fields", "100000", "FIELDS", "1", "f1"), aof)
+
+	b := encodeRdb()
+	keyspace["fields"].value.(*redisHash).setExpire("f1", time.Time{})
+	expected := dataset()
+	keyspace = map[string]*redisObject{}
+	if err := decodeRdb(b); err != nil {
+		t.Fatal(err)
+	}
+	// Field expirations are dropped
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestConvertDump(t *testing.T) {
+	aof := newTestAof(t)
+	dir := t.TempDir()
+	keyspace = map[string]*redisObject{}
+	processRequest(nil, request("SET", "key", "value"), aof)
+	processRequest(nil, request("RPUSH", "list", "a", "b"), aof)
+	expected := dataset()
+	os.WriteFile(filepath.Join(dir, "dump.rdb"), encodeRdb(), 0666)
+
+	if _, err := ConvertDump(filepath.Join(dir, "dump.rdb"), filepath.Join(dir, "dump.snap")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertDump(filepath.Join(dir, "dump.snap"), filepath.Join(dir, "back.rdb")); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "back.rdb"))
+	if !bytes.HasPrefix(b, []byte("REDIS0011")) {
+		t.Fatalf("Expected an RDB file, got %q", b[:9])
+	}
+
+	// RDB files are loaded at startup as snapshots
+	keyspace = map[string]*redisObject{}
+	if err := loadSnapshot(filepath.Join(dir, "back.rdb")); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/config"
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Server wide settings and background tasks, plus the CONFIG and INFO
+// commands to inspect them
+
+// Version of Redis whose behavior is implemented, reported to clients by
+// HELLO and INFO
+const redisVersion = "7.4.0"
+
+var serverConfig = config.New()
+
+// Init applies the configuration and starts the background tasks
+func Init(cfg *config.Config) {
+	serverConfig = cfg
+	go serverCron()
+}
+
+// serverCron runs the periodic tasks hz times per second
+func serverCron() {
+	for {
+		hz := serverConfig.Int("hz")
+		time.Sleep(time.Second / time.Duration(hz))
+
+		keyspaceLock.Lock()
+		activeExpireCycle(hz)
+		snapshotCron(time.Now())
+		keyspaceLock.Unlock()
+	}
+}
+
+// CONFIG GET pattern [pattern ...]
+// CONFIG SET parameter value [parameter value ...]
+func configCmd(a *Aof, p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	switch strings.ToUpper(string(p[0].Bulk)) {
+	case "GET":
+		var values []string
+		seen := map[string]bool{}
+		for _, pattern := range p[1:] {
+			for _, name := range serverConfig.Match(string(pattern.Bulk)) {
+				if !seen[name] {
+					seen[name] = true
+					value, _ := serverConfig.Get(name)
+					values = append(values, name, value)
+				}
+			}
+		}
+		return bulkMap(values)
+	case "SET":
+		if len(p)%2 == 0 {
+			return missingArgumentsError
+		}
+		for i := 1; i < len(p); i += 2 {
+			name, value := string(p[i].Bulk), string(p[i+1].Bulk)
+			old, _ := serverConfig.Get(name)
+			if err := serverConfig.Update(name, value); err != nil {
+				return resp.NewError("ERR", "CONFIG SET failed - "+err.Error())
+			}
+			if err := applyConfig(a, name); err != nil {
+				serverConfig.Set(name, old)
+				return resp.NewError("ERR", "CONFIG SET failed - "+err.Error())
+			}
+		}
+		return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+	}
+	return syntaxError
+}
+
+// applyConfig applies a setting changed at runtime, for the settings not
+// simply read when used
+func applyConfig(a *Aof, name string) error {
+	switch strings.ToLower(name) {
+	case "appendonly":
+		if serverConfig.Enum("appendonly") == "yes" {
+			return a.startAppendOnly()
+		}
+		return a.Close()
+	}
+	return nil
+}
+
+// Sections of the INFO reply, in display order
+var infoSections = []struct {
+	name    string
+	content func(a *Aof) []string
+}{
+	{"server", serverInfo},
+	{"persistence", persistenceInfo},
+	{"stats", statsInfo},
+	{"keyspace", keyspaceInfo},
+}
+
+func serverInfo(a *Aof) []string {
+	return []string{
+		"redis_version:" + redisVersion,
+		"redis_mode:standalone",
+		fmt.Sprintf("hz:%d", serverConfig.Int("hz")),
+	}
+}
+
+func persistenceInfo(a *Aof) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var inProgress int
+	if a.rewriting {
+		inProgress = 1
+	}
+	status := "ok"
+	if a.lastRewriteErr != nil {
+		status = "err"
+	}
+	var saving, aofEnabled int
+	if saver.saving {
+		saving = 1
+	}
+	if a.file != nil {
+		aofEnabled = 1
+	}
+	saveStatus := "ok"
+	if saver.lastErr != nil {
+		saveStatus = "err"
+	}
+	writeStatus := "ok"
+	if a.lastWriteErr != nil {
+		writeStatus = "err"
+	}
+	return []string{
+		fmt.Sprintf("rdb_changes_since_last_save:%d", dirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", saving),
+		fmt.Sprintf("rdb_last_save_time:%d", saver.lastSave.Unix()),
+		"rdb_last_bgsave_status:" + saveStatus,
+		fmt.Sprintf("aof_enabled:%d", aofEnabled),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", inProgress),
+		"aof_last_bgrewrite_status:" + status,
+		"aof_last_write_status:" + writeStatus,
+		fmt.Sprintf("aof_rewrites:%d", a.rewrites),
+		fmt.Sprintf("aof_current_size:%d", a.size),
+		fmt.Sprintf("aof_base_size:%d", a.baseSize),
+		"aof_fsync_policy:" + serverConfig.Enum("appendfsync"),
+		fmt.Sprintf("aof_fsync_latency_usec:%d", a.fsyncLatency.Microseconds()),
+		fmt.Sprintf("aof_fsync_max_latency_usec:%d", a.maxFsyncLatency.Microseconds()),
+		fmt.Sprintf("aof_delayed_fsync:%d", a.delayedFsyncs),
+	}
+}
+
+func statsInfo(a *Aof) []string {
+	return []string{
+		fmt.Sprintf("expired_keys:%d", stats.expiredKeys),
+		fmt.Sprintf("expired_subkeys:%d", stats.expiredSubkeys),
+		fmt.Sprintf("expired_stale_perc:%.2f", stats.expiredStalePerc*100),
+		fmt.Sprintf("expired_time_cap_reached_count:%d", stats.expiredTimeCapReached),
+		fmt.Sprintf("expire_cycle_cpu_milliseconds:%d", stats.expireCycleTime.Milliseconds()),
+	}
+}
+
+func keyspaceInfo(a *Aof) []string {
+	var keys, expires int
+	for _, obj := range keyspace {
+		keys++
+		if !obj.expire.IsZero() {
+			expires++
+		}
+	}
+	if keys == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("db0:keys=%d,expires=%d", keys, expires)}
+}
+
+// INFO [section [section ...]]
+func info(a *Aof, p []resp.Payload) resp.Payload {
+	wanted := map[string]bool{}
+	for _, section := range p {
+		wanted[strings.ToLower(string(section.Bulk))] = true
+	}
+	all := len(p) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, line := range section.content(a) {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	return resp.Payload{DataType: string(resp.VERBATIM), Str: "txt", Bulk: []byte(b.String())}
+}
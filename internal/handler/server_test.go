@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func TestConfig(t *testing.T) {
+	if response := configCmd(nil, args("SET", "hz", "20")); response.Str != "OK" {
+		t.Errorf("Expected OK, got %v", response)
+	}
+	defer configCmd(nil, args("SET", "hz", "10"))
+	if got := bulks(configCmd(nil, args("GET", "h*"))); !reflect.DeepEqual(got, []string{"hz", "20"}) {
+		t.Errorf("Expected [hz 20], got %v", got)
+	}
+	if got := bulks(configCmd(nil, args("GET", "unknown"))); len(got) != 0 {
+		t.Errorf("Expected no value, got %v", got)
+	}
+	for _, invalid := range [][]string{{"SET", "hz", "0"}, {"SET", "unknown", "1"}, {"SET", "hz"}, {"RESET", "x"}} {
+		if response := configCmd(nil, args(invalid...)); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected error for %v, got %v", invalid, response)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+	set(args("key", "value", "EX", "100"))
+	set(args("other", "value"))
+
+	response := info(aof, nil)
+	for _, expected := range []string{"# Server\r\n", "# Persistence\r\n", "aof_rewrite_in_progress:0", "# Stats\r\n", "expired_keys:", "expired_subkeys:", "db0:keys=2,expires=1"} {
+		if !strings.Contains(string(response.Bulk), expected) {
+			t.Errorf("Expected INFO to contain %q, got %q", expected, string(response.Bulk))
+		}
+	}
+	response = info(aof, args("stats"))
+	if !strings.HasPrefix(string(response.Bulk), "# Stats\r\n") || strings.Contains(string(response.Bulk), "# Keyspace") {
+		t.Errorf("Expected only the stats section, got %q", string(response.Bulk))
+	}
+}
@@ -0,0 +1,557 @@
+package handler
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Sets are stored as a sorted array of integers while they are small and
+// only hold integers, and converted to a hash table otherwise.
+
+const setMaxIntsetEntries = 512
+
+type redisSet struct {
+	// intset is used as long as dict is nil
+	intset []int64
+	// dict maps the members to their index in entries, so that members can
+	// be picked at random
+	dict    map[string]int
+	entries []string
+}
+
+func newSet() *redisSet {
+	return &redisSet{}
+}
+
+// intsetValue reports whether member can be stored in the intset, its string
+// form must be the canonical one so it can be given back unchanged
+func intsetValue(member string) (int64, bool) {
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+func (s *redisSet) convertToDict() {
+	s.dict = make(map[string]int, len(s.intset))
+	s.entries = make([]string, 0, len(s.intset))
+	for _, v := range s.intset {
+		s.dict[strconv.FormatInt(v, 10)] = len(s.entries)
+		s.entries = append(s.entries, strconv.FormatInt(v, 10))
+	}
+	s.intset = nil
+}
+
+func (s *redisSet) add(member string) bool {
+	if s.dict == nil {
+		if v, ok := intsetValue(member); ok {
+			i, found := slices.BinarySearch(s.intset, v)
+			if found {
+				return false
+			}
+			if len(s.intset) < setMaxIntsetEntries {
+				s.intset = slices.Insert(s.intset, i, v)
+				return true
+			}
+		}
+		s.convertToDict()
+	}
+	if _, ok := s.dict[member]; ok {
+		return false
+	}
+	s.dict[member] = len(s.entries)
+	s.entries = append(s.entries, member)
+	return true
+}
+
+func (s *redisSet) remove(member string) bool {
+	if s.dict == nil {
+		v, ok := intsetValue(member)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(s.intset, v)
+		if found {
+			s.intset = slices.Delete(s.intset, i, i+1)
+		}
+		return found
+	}
+	i, ok := s.dict[member]
+	if !ok {
+		return false
+	}
+	// The last entry takes the place of the removed one
+	last := s.entries[len(s.entries)-1]
+	s.entries[i] = last
+	s.dict[last] = i
+	s.entries = s.entries[:len(s.entries)-1]
+	delete(s.dict, member)
+	return true
+}
+
+func (s *redisSet) contains(member string) bool {
+	if s.dict == nil {
+		v, ok := intsetValue(member)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(s.intset, v)
+		return found
+	}
+	_, ok := s.dict[member]
+	return ok
+}
+
+func (s *redisSet) len() int {
+	if s.dict == nil {
+		return len(s.intset)
+	}
+	return len(s.dict)
+}
+
+func (s *redisSet) members() []string {
+	members := make([]string, 0, s.len())
+	if s.dict == nil {
+		for _, v := range s.intset {
+			members = append(members, strconv.FormatInt(v, 10))
+		}
+		return members
+	}
+	return append(members, s.entries...)
+}
+
+// member returns the member at index i of the intset or of entries
+func (s *redisSet) member(i int) string {
+	if s.dict == nil {
+		return strconv.FormatInt(s.intset[i], 10)
+	}
+	return s.entries[i]
+}
+
+// randomMembers returns count distinct members chosen at random
+func (s *redisSet) randomMembers(count int) []string {
+	indexes := randomIndexes(s.len(), count)
+	members := make([]string, len(indexes))
+	for i, j := range indexes {
+		members[i] = s.member(j)
+	}
+	return members
+}
+
+// randomIndexes returns count distinct indexes below n chosen at random, with
+// a partial Fisher-Yates shuffle. The indexes moved by the shuffle are kept
+// in a map, so picking count indexes takes O(count) whatever n is.
+func randomIndexes(n, count int) []int {
+	count = min(count, n)
+	indexes := make([]int, count)
+	moved := make(map[int]int, count)
+	index := func(i int) int {
+		if j, ok := moved[i]; ok {
+			return j
+		}
+		return i
+	}
+	for i := range indexes {
+		j := i + rand.Intn(n-i)
+		indexes[i] = index(j)
+		moved[j] = index(i)
+	}
+	return indexes
+}
+
+// lookupSets returns the sets stored at keys, missing keys are nil
+func lookupSets(keys []resp.Payload) ([]*redisSet, bool) {
+	sets := make([]*redisSet, len(keys))
+	for i, k := range keys {
+		obj, ok := lookupTyped(string(k.Bulk), setType)
+		if !ok {
+			return nil, false
+		}
+		if obj != nil {
+			sets[i] = obj.value.(*redisSet)
+		}
+	}
+	return sets, true
+}
+
+func sadd(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), setType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		obj = &redisObject{kind: setType, value: newSet()}
+		setKey(string(p[0].Bulk), obj)
+	}
+	s := obj.value.(*redisSet)
+	var added int
+	for _, m := range p[1:] {
+		if s.add(string(m.Bulk)) {
+			added++
+		}
+	}
+	dirty += added
+	return resp.Payload{DataType: string(resp.INTEGER), Num: added}
+}
+
+func srem(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	obj, ok := lookupTyped(string(p[0].Bulk), setType)
+	if !ok {
+		return wrongTypeError
+	}
+	var removed int
+	if obj != nil {
+		s := obj.value.(*redisSet)
+		for _, m := range p[1:] {
+			if s.remove(string(m.Bulk)) {
+				removed++
+			}
+		}
+		dirty += removed
+		if s.len() == 0 {
+			delete(keyspace, string(p[0].Bulk))
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: removed}
+}
+
+func smembers(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p)
+	if !ok {
+		return wrongTypeError
+	}
+	if sets[0] == nil {
+		return bulkSet(nil)
+	}
+	return bulkSet(sets[0].members())
+}
+
+func sismember(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p[:1])
+	if !ok {
+		return wrongTypeError
+	}
+	var found int
+	if sets[0] != nil && sets[0].contains(string(p[1].Bulk)) {
+		found = 1
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: found}
+}
+
+func smismember(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p[:1])
+	if !ok {
+		return wrongTypeError
+	}
+	array := make([]resp.Payload, 0, len(p)-1)
+	for _, m := range p[1:] {
+		var found int
+		if sets[0] != nil && sets[0].contains(string(m.Bulk)) {
+			found = 1
+		}
+		array = append(array, resp.Payload{DataType: string(resp.INTEGER), Num: found})
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: array}
+}
+
+func scard(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p)
+	if !ok {
+		return wrongTypeError
+	}
+	var card int
+	if sets[0] != nil {
+		card = sets[0].len()
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: card}
+}
+
+// SMOVE source destination member
+func smove(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p[:2])
+	if !ok {
+		return wrongTypeError
+	}
+	src, dst := sets[0], sets[1]
+	if string(p[0].Bulk) == string(p[1].Bulk) {
+		return sismember([]resp.Payload{p[0], p[2]})
+	}
+	if src == nil || !src.remove(string(p[2].Bulk)) {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+	}
+	if src.len() == 0 {
+		delete(keyspace, string(p[0].Bulk))
+	}
+	if dst == nil {
+		dst = newSet()
+		setKey(string(p[1].Bulk), &redisObject{kind: setType, value: dst})
+	}
+	dst.add(string(p[2].Bulk))
+	dirty++
+	return resp.Payload{DataType: string(resp.INTEGER), Num: 1}
+}
+
+// SPOP key [count]
+func spop(p []resp.Payload) resp.Payload {
+	if len(p) != 1 && len(p) != 2 {
+		return missingArgumentsError
+	}
+	count := -1
+	if len(p) == 2 {
+		n, err := strconv.Atoi(string(p[1].Bulk))
+		if err != nil || n < 0 {
+			return resp.NewError("ERR", "value is out of range, must be positive")
+		}
+		count = n
+	}
+	sets, ok := lookupSets(p[:1])
+	if !ok {
+		return wrongTypeError
+	}
+	s := sets[0]
+	if s == nil {
+		if count == -1 {
+			return resp.NullBulk
+		}
+		return bulkArray(nil)
+	}
+
+	n := count
+	if count == -1 {
+		n = 1
+	}
+	members := s.randomMembers(n)
+	for _, m := range members {
+		s.remove(m)
+	}
+	dirty += len(members)
+	// The members are chosen at random, their removal is logged instead
+	if len(members) > 0 {
+		alsoPropagate(append([]string{"SREM", string(p[0].Bulk)}, members...)...)
+	}
+	if s.len() == 0 {
+		delete(keyspace, string(p[0].Bulk))
+	}
+	if count == -1 {
+		return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(members[0])}
+	}
+	return bulkArray(members)
+}
+
+// Largest number of members repeated by a negative count
+const maxRandomReply = 1 << 24
+
+// parseRandomCount parses the count of SRANDMEMBER and HRANDFIELD, which
+// repeats members when negative. As in Redis, counts beyond half the int64
+// range are refused, and negative ones below -maxRandomReply as well, about
+// 16 million members, since such a reply would exhaust the memory.
+func parseRandomCount(s string) (int, resp.Payload) {
+	count, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, notIntegerError
+	}
+	if count > math.MaxInt64/2 || count < -maxRandomReply {
+		return 0, resp.NewError("ERR", "value is out of range")
+	}
+	return int(count), resp.Payload{}
+}
+
+// SRANDMEMBER key [count]
+// A negative count allows the same member to be returned several times
+func srandmember(p []resp.Payload) resp.Payload {
+	if len(p) != 1 && len(p) != 2 {
+		return missingArgumentsError
+	}
+	count, withCount := 1, len(p) == 2
+	if withCount {
+		n, errPayload := parseRandomCount(string(p[1].Bulk))
+		if errPayload.DataType != "" {
+			return errPayload
+		}
+		count = n
+	}
+	sets, ok := lookupSets(p[:1])
+	if !ok {
+		return wrongTypeError
+	}
+	s := sets[0]
+	if s == nil {
+		if !withCount {
+			return resp.NullBulk
+		}
+		return bulkArray(nil)
+	}
+
+	if !withCount {
+		return resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(s.randomMembers(1)[0])}
+	}
+	if count >= 0 {
+		return bulkArray(s.randomMembers(count))
+	}
+	members := make([]string, -count)
+	for i := range members {
+		members[i] = s.member(rand.Intn(s.len()))
+	}
+	return bulkArray(members)
+}
+
+// setInter returns the members found in all the sets, it stops once limit
+// members are found when limit is positive
+func setInter(sets []*redisSet, limit int) []string {
+	for _, s := range sets {
+		if s == nil {
+			return nil
+		}
+	}
+	// Iterate on the smallest set
+	slices.SortFunc(sets, func(a, b *redisSet) int { return a.len() - b.len() })
+	result := []string{}
+	for _, m := range sets[0].members() {
+		inAll := true
+		for _, s := range sets[1:] {
+			if !s.contains(m) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			result = append(result, m)
+			if limit > 0 && len(result) == limit {
+				break
+			}
+		}
+	}
+	return result
+}
+
+func setUnion(sets []*redisSet) []string {
+	union := newSet()
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		for _, m := range s.members() {
+			union.add(m)
+		}
+	}
+	return union.members()
+}
+
+func setDiff(sets []*redisSet) []string {
+	if sets[0] == nil {
+		return nil
+	}
+	result := []string{}
+	for _, m := range sets[0].members() {
+		found := false
+		for _, s := range sets[1:] {
+			if s != nil && s.contains(m) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func setAlgebra(p []resp.Payload, op func([]*redisSet) []string) resp.Payload {
+	if len(p) < 1 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p)
+	if !ok {
+		return wrongTypeError
+	}
+	return bulkSet(op(sets))
+}
+
+// setAlgebraStore stores the result in the first key, which is removed when
+// the result is empty
+func setAlgebraStore(p []resp.Payload, op func([]*redisSet) []string) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	sets, ok := lookupSets(p[1:])
+	if !ok {
+		return wrongTypeError
+	}
+	members := op(sets)
+	deleteKey(string(p[0].Bulk))
+	if len(members) > 0 {
+		s := newSet()
+		for _, m := range members {
+			s.add(m)
+		}
+		setKey(string(p[0].Bulk), &redisObject{kind: setType, value: s})
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: len(members)}
+}
+
+func interAll(sets []*redisSet) []string { return setInter(sets, 0) }
+
+func sinter(p []resp.Payload) resp.Payload      { return setAlgebra(p, interAll) }
+func sunion(p []resp.Payload) resp.Payload      { return setAlgebra(p, setUnion) }
+func sdiff(p []resp.Payload) resp.Payload       { return setAlgebra(p, setDiff) }
+func sinterstore(p []resp.Payload) resp.Payload { return setAlgebraStore(p, interAll) }
+func sunionstore(p []resp.Payload) resp.Payload { return setAlgebraStore(p, setUnion) }
+func sdiffstore(p []resp.Payload) resp.Payload  { return setAlgebraStore(p, setDiff) }
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercard(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	numkeys, err := strconv.Atoi(string(p[0].Bulk))
+	if err != nil || numkeys <= 0 {
+		return resp.NewError("ERR", "numkeys should be greater than 0")
+	}
+	if numkeys > len(p)-1 {
+		return resp.NewError("ERR", "Number of keys can't be greater than number of args")
+	}
+	var limit int
+	rest := p[numkeys+1:]
+	for i := 0; i < len(rest); i += 2 {
+		if strings.ToUpper(string(rest[i].Bulk)) != "LIMIT" || i+1 >= len(rest) {
+			return syntaxError
+		}
+		limit, err = strconv.Atoi(string(rest[i+1].Bulk))
+		if err != nil || limit < 0 {
+			return resp.NewError("ERR", "LIMIT can't be negative")
+		}
+	}
+	sets, ok := lookupSets(p[1 : numkeys+1])
+	if !ok {
+		return wrongTypeError
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: len(setInter(sets, limit))}
+}
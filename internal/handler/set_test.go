@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func sortedBulks(p resp.Payload) []string {
+	values := bulks(p)
+	slices.Sort(values)
+	return values
+}
+
+func TestSetEncoding(t *testing.T) {
+	s := newSet()
+	for i := 0; i < setMaxIntsetEntries; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	if s.dict != nil || s.len() != setMaxIntsetEntries {
+		t.Fatalf("Expected intset encoding with %d members", setMaxIntsetEntries)
+	}
+	// Non canonical integers are kept as strings
+	s2 := newSet()
+	s2.add("007")
+	if s2.dict == nil || !s2.contains("007") || s2.contains("7") {
+		t.Errorf("Expected 007 to be stored as a string")
+	}
+
+	s.add(strconv.Itoa(setMaxIntsetEntries))
+	if s.dict == nil || s.len() != setMaxIntsetEntries+1 || !s.contains("0") {
+		t.Errorf("Expected conversion to dict")
+	}
+}
+
+func TestRandomMembers(t *testing.T) {
+	for _, n := range []int{10, 1000} {
+		s := newSet()
+		for i := 0; i < n; i++ {
+			s.add("m" + strconv.Itoa(i))
+		}
+		for i := 0; i < n; i += 3 {
+			s.remove("m" + strconv.Itoa(i))
+		}
+		for _, count := range []int{1, 5, s.len(), s.len() + 10} {
+			seen := map[string]bool{}
+			for _, m := range s.randomMembers(count) {
+				if seen[m] || !s.contains(m) {
+					t.Fatalf("Unexpected member %s among %v", m, seen)
+				}
+				seen[m] = true
+			}
+			if len(seen) != min(count, s.len()) {
+				t.Errorf("Expected %d members, got %d", min(count, s.len()), len(seen))
+			}
+		}
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	del(args("set"))
+
+	if response := sadd(args("set", "a", "b", "1", "a")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	if response := scard(args("set")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	if response := sismember(args("set", "1")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	response := smismember(args("set", "a", "z"))
+	if response.Array[0].Num != 1 || response.Array[1].Num != 0 {
+		t.Errorf("Expected [1 0], got %v", response)
+	}
+	if response := srem(args("set", "a", "z")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if got := sortedBulks(smembers(args("set"))); !reflect.DeepEqual(got, []string{"1", "b"}) {
+		t.Errorf("Unexpected members %v", got)
+	}
+	if got := srandmember(args("set", "-5")); len(got.Array) != 5 {
+		t.Errorf("Expected 5 members, got %v", got)
+	}
+	if got := srandmember(args("set", "5")); len(got.Array) != 2 {
+		t.Errorf("Expected 2 members, got %v", got)
+	}
+	for _, count := range []string{"-9223372036854775808", "-4611686018427387904", "4611686018427387904", "-100000000"} {
+		if got := srandmember(args("set", count)); got.ErrorCode() != "ERR" {
+			t.Errorf("Expected count %s to be out of range, got %v", count, got)
+		}
+	}
+	if got := spop(args("set", "0")); len(got.Array) != 0 || scard(args("set")).Num != 2 {
+		t.Errorf("Expected nothing to be popped, got %v", got)
+	}
+	spop(args("set", "10"))
+	if exist(args("set")).Num != 0 {
+		t.Errorf("Expected set to be deleted")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	del(args("s1", "s2", "s3", "dst"))
+	sadd(args("s1", "a", "b", "c", "d"))
+	sadd(args("s2", "c", "d", "e"))
+	sadd(args("s3", "d"))
+
+	if got := sortedBulks(sinter(args("s1", "s2"))); !reflect.DeepEqual(got, []string{"c", "d"}) {
+		t.Errorf("Unexpected inter %v", got)
+	}
+	if got := sortedBulks(sinter(args("s1", "nonexisting"))); len(got) != 0 {
+		t.Errorf("Unexpected inter %v", got)
+	}
+	if got := sortedBulks(sunion(args("s1", "s2"))); !reflect.DeepEqual(got, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Unexpected union %v", got)
+	}
+	if got := sortedBulks(sdiff(args("s1", "s2", "s3"))); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Unexpected diff %v", got)
+	}
+	if response := sintercard(args("2", "s1", "s2", "LIMIT", "1")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := sintercard(args("3", "s1", "s2", "s3")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	for _, numkeys := range []string{"0", "4", "9223372036854775807", "-9223372036854775808"} {
+		if response := sintercard(args(numkeys, "s1", "s2", "s3")); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected an error for numkeys %s, got %v", numkeys, response)
+		}
+	}
+
+	if response := sunionstore(args("dst", "s2", "s3")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	if got := sortedBulks(smembers(args("dst"))); !reflect.DeepEqual(got, []string{"c", "d", "e"}) {
+		t.Errorf("Unexpected members %v", got)
+	}
+	sinterstore(args("dst", "s1", "nonexisting"))
+	if exist(args("dst")).Num != 0 {
+		t.Errorf("Expected empty result to delete destination")
+	}
+
+	if response := smove(args("s3", "s1", "d")); response.Num != 1 || exist(args("s3")).Num != 0 {
+		t.Errorf("Expected d to be moved, got %v", response)
+	}
+
+	set(args("str", "value"))
+	if response := sunion(args("s1", "str")); response.Str != wrongTypeError.Str {
+		t.Errorf("Expected WRONGTYPE, got %v", response)
+	}
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Skiplist ordering the members of a sorted set by score, then by member.
+// Every level keeps the number of nodes it skips (span) so that the rank of
+// a node can be computed while walking the list.
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplist struct {
+	header, tail *zskiplistNode
+	length       int
+	level        int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n is ordered before (score, member)
+func (n *zskiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// Levels above the new node now skip one more node
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, update)
+	return true
+}
+
+// rank returns the 1-based rank of the member, or 0 when it is not found
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.before(score, member) || x.level[i].forward.member == member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// Score range, bounds are inclusive unless minex or maxex is set
+type zrangespec struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *zrangespec) gteMin(v float64) bool {
+	if r.minex {
+		return v > r.min
+	}
+	return v >= r.min
+}
+
+func (r *zrangespec) lteMax(v float64) bool {
+	if r.maxex {
+		return v < r.max
+	}
+	return v <= r.max
+}
+
+func (zsl *zskiplist) isInRange(r *zrangespec) bool {
+	if r.min > r.max || (r.min == r.max && (r.minex || r.maxex)) {
+		return false
+	}
+	if zsl.tail == nil || !r.gteMin(zsl.tail.score) {
+		return false
+	}
+	first := zsl.header.level[0].forward
+	return first != nil && r.lteMax(first.score)
+}
+
+func (zsl *zskiplist) firstInRange(r *zrangespec) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *zskiplist) lastInRange(r *zrangespec) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// Bound of a lexicographical range, inf is -1 for "-" and 1 for "+"
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int
+}
+
+// compare returns the order of the bound relatively to v
+func (b *lexBound) compare(v string) int {
+	if b.inf != 0 {
+		return b.inf
+	}
+	return strings.Compare(b.value, v)
+}
+
+type zlexrangespec struct {
+	min, max lexBound
+}
+
+func (r *zlexrangespec) gteMin(v string) bool {
+	c := r.min.compare(v)
+	if r.min.exclusive {
+		return c < 0
+	}
+	return c <= 0
+}
+
+func (r *zlexrangespec) lteMax(v string) bool {
+	c := r.max.compare(v)
+	if r.max.exclusive {
+		return c > 0
+	}
+	return c >= 0
+}
+
+func (r *zlexrangespec) empty() bool {
+	if r.min.inf == 1 || r.max.inf == -1 {
+		return true
+	}
+	if r.min.inf == -1 || r.max.inf == 1 {
+		return false
+	}
+	c := strings.Compare(r.min.value, r.max.value)
+	return c > 0 || (c == 0 && (r.min.exclusive || r.max.exclusive))
+}
+
+func (zsl *zskiplist) isInLexRange(r *zlexrangespec) bool {
+	if r.empty() || zsl.tail == nil || !r.gteMin(zsl.tail.member) {
+		return false
+	}
+	return r.lteMax(zsl.header.level[0].forward.member)
+}
+
+func (zsl *zskiplist) firstInLexRange(r *zlexrangespec) *zskiplistNode {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *zskiplist) lastInLexRange(r *zlexrangespec) *zskiplistNode {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.member) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSkiplistRanks(t *testing.T) {
+	zsl := newZskiplist()
+	scores := map[string]float64{}
+	for i := 0; i < 1000; i++ {
+		member := "m" + strconv.Itoa(i)
+		scores[member] = float64(rand.Intn(100))
+		zsl.insert(scores[member], member)
+	}
+	for i := 0; i < 1000; i += 3 {
+		member := "m" + strconv.Itoa(i)
+		if !zsl.delete(scores[member], member) {
+			t.Fatalf("Expected %s to be deleted", member)
+		}
+		delete(scores, member)
+	}
+	if zsl.delete(1000, "nonexisting") {
+		t.Errorf("Expected nothing to be deleted")
+	}
+
+	members := make([]string, 0, len(scores))
+	for m := range scores {
+		members = append(members, m)
+	}
+	slices.SortFunc(members, func(a, b string) int {
+		if scores[a] != scores[b] {
+			return int(scores[a] - scores[b])
+		}
+		if a < b {
+			return -1
+		}
+		return 1
+	})
+
+	if zsl.length != len(members) {
+		t.Fatalf("Expected %d nodes, got %d", len(members), zsl.length)
+	}
+	for i, m := range members {
+		if rank := zsl.rank(scores[m], m); rank != i+1 {
+			t.Fatalf("Expected rank %d for %s, got %d", i+1, m, rank)
+		}
+		if n := zsl.byRank(i + 1); n.member != m {
+			t.Fatalf("Expected %s at rank %d, got %s", m, i+1, n.member)
+		}
+	}
+	if zsl.tail.member != members[len(members)-1] || zsl.header.level[0].forward.backward != nil {
+		t.Errorf("Unexpected head or tail")
+	}
+}
+
+func TestSkiplistRanges(t *testing.T) {
+	zsl := newZskiplist()
+	for i, m := range []string{"a", "b", "c", "d", "e"} {
+		zsl.insert(float64(i), m)
+	}
+
+	r := &zrangespec{min: 1, max: 3, minex: true}
+	if first, last := zsl.firstInRange(r), zsl.lastInRange(r); first.member != "c" || last.member != "d" {
+		t.Errorf("Expected c..d, got %s..%s", first.member, last.member)
+	}
+	if n := zsl.firstInRange(&zrangespec{min: 10, max: 20}); n != nil {
+		t.Errorf("Expected empty range, got %s", n.member)
+	}
+
+	lr := &zlexrangespec{min: lexBound{value: "b", exclusive: true}, max: lexBound{inf: 1}}
+	if first, last := zsl.firstInLexRange(lr), zsl.lastInLexRange(lr); first.member != "c" || last.member != "e" {
+		t.Errorf("Expected c..e, got %s..%s", first.member, last.member)
+	}
+}
@@ -0,0 +1,559 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Point-in-time snapshots of the dataset, written by SAVE, BGSAVE and the save
+// rules, and loaded at startup when the AOF is disabled.
+//
+// The file starts with the magic string REDISLITE and a 4 digits version,
+// followed by the aux fields and the keys, and ends with an EOF opcode and
+// the CRC64 of everything before it:
+//
+//	REDISLITE0001
+//	AUX name value
+//	[EXPIRE unix-time-milliseconds] type key value
+//	...
+//	EOF crc64
+//
+// Lengths and integers are varints, strings are a length and their bytes.
+// As for AOF rewrites, Go can not fork: the dataset is encoded while the
+// keyspace is locked and only written to disk in the background.
+
+const (
+	snapshotMagic   = "REDISLITE"
+	snapshotVersion = 1
+)
+
+// Opcodes, and the types of the values following a key
+const (
+	snapshotString  = 0
+	snapshotList    = 1
+	snapshotSet     = 2
+	snapshotZset    = 3
+	snapshotHash    = 4
+	snapshotIntset  = 5
+	snapshotHashTTL = 6
+
+	snapshotOpAux    = 0xfa
+	snapshotOpExpire = 0xfc
+	snapshotOpEOF    = 0xff
+)
+
+// After a failed BGSAVE, the save rules wait this long before trying again
+const snapshotRetryDelay = 5 * time.Second
+
+// snapshotPath is the snapshot file, dbfilename in dir
+func snapshotPath() string {
+	dir, _ := serverConfig.Get("dir")
+	name, _ := serverConfig.Get("dbfilename")
+	return filepath.Join(dir, name)
+}
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var errSaveInProgress = errors.New("Background save already in progress")
+
+// State of the snapshots, guarded by keyspaceLock
+var saver = struct {
+	saving bool
+	// Value of dirty when the BGSAVE in progress started
+	dirtyBeforeSave int
+	// Last successful save, and last attempt of a BGSAVE with its result
+	lastSave time.Time
+	lastTry  time.Time
+	lastErr  error
+}{lastSave: time.Now()}
+
+type snapshotEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *snapshotEncoder) object(key string, obj *redisObject, now time.Time) {
+	if !obj.expire.IsZero() {
+		e.buf.WriteByte(snapshotOpExpire)
+		e.varint(obj.expire.UnixMilli())
+	}
+	switch obj.kind {
+	case stringType:
+		e.buf.WriteByte(snapshotString)
+		e.string(key)
+		e.string(obj.value.(string))
+	case listType:
+		l := obj.value.(*quicklist)
+		e.buf.WriteByte(snapshotList)
+		e.string(key)
+		e.uvarint(uint64(l.len()))
+		it := l.iterator(0, false)
+		for v, ok := it.next(); ok; v, ok = it.next() {
+			e.string(v)
+		}
+	case setType:
+		s := obj.value.(*redisSet)
+		if s.dict == nil {
+			e.buf.WriteByte(snapshotIntset)
+			e.string(key)
+			e.uvarint(uint64(len(s.intset)))
+			for _, v := range s.intset {
+				e.varint(v)
+			}
+			break
+		}
+		e.buf.WriteByte(snapshotSet)
+		e.string(key)
+		e.uvarint(uint64(len(s.dict)))
+		for member := range s.dict {
+			e.string(member)
+		}
+	case zsetType:
+		z := obj.value.(*sortedSet)
+		e.buf.WriteByte(snapshotZset)
+		e.string(key)
+		e.uvarint(uint64(z.len()))
+		for n := z.zsl.header.level[0].forward; n != nil; n = n.level[0].forward {
+			e.string(n.member)
+			e.buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(n.score)))
+		}
+	case hashType:
+		h := obj.value.(*redisHash)
+		fields := make(map[string]stringValue, h.len())
+		for f, v := range h.fields {
+			if v.expire.IsZero() || v.expire.After(now) {
+				fields[f] = v
+			}
+		}
+		// Hashes without field expirations do not pay for them
+		if h.nextExpire.IsZero() {
+			e.buf.WriteByte(snapshotHash)
+		} else {
+			e.buf.WriteByte(snapshotHashTTL)
+		}
+		e.string(key)
+		e.uvarint(uint64(len(fields)))
+		for f, v := range fields {
+			e.string(f)
+			e.string(v.value)
+			if !h.nextExpire.IsZero() {
+				var ms int64
+				if !v.expire.IsZero() {
+					ms = v.expire.UnixMilli()
+				}
+				e.varint(ms)
+			}
+		}
+	}
+}
+
+// encodeSnapshot returns the content of a snapshot of the dataset,
+// keyspaceLock must be held
+func encodeSnapshot() []byte {
+	now := time.Now()
+	e := &snapshotEncoder{}
+	e.buf.WriteString(fmt.Sprintf("%s%04d", snapshotMagic, snapshotVersion))
+	for _, aux := range [][2]string{
+		{"redis-ver", redisVersion},
+		{"ctime", strconv.FormatInt(now.Unix(), 10)},
+	} {
+		e.buf.WriteByte(snapshotOpAux)
+		e.string(aux[0])
+		e.string(aux[1])
+	}
+	for key, obj := range keyspace {
+		if obj.isExpired(now) {
+			continue
+		}
+		e.object(key, obj, now)
+	}
+	e.buf.WriteByte(snapshotOpEOF)
+	return binary.LittleEndian.AppendUint64(e.buf.Bytes(), crc64.Checksum(e.buf.Bytes(), crcTable))
+}
+
+// writeSnapshot writes the snapshot to a temporary file renamed over the
+// previous one once synced, so a crash never leaves a partial snapshot. It is
+// encrypted when encryption is enabled.
+func writeSnapshot(b []byte, name string) error {
+	b = sealFile(b)
+	// Not created at startup when running in memory
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "temp-*.snap")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+var errSnapshotCorrupted = errors.New("snapshot is corrupted")
+
+type snapshotDecoder struct {
+	b []byte
+}
+
+func (d *snapshotDecoder) byte() (byte, error) {
+	if len(d.b) == 0 {
+		return 0, errSnapshotCorrupted
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c, nil
+}
+
+func (d *snapshotDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errSnapshotCorrupted
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *snapshotDecoder) varint() (int64, error) {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		return 0, errSnapshotCorrupted
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+// len reads a number of items, each taking at least one byte
+func (d *snapshotDecoder) len() (int, error) {
+	v, err := d.uvarint()
+	if err != nil || v > uint64(len(d.b)) {
+		return 0, errSnapshotCorrupted
+	}
+	return int(v), nil
+}
+
+func (d *snapshotDecoder) string() (string, error) {
+	n, err := d.len()
+	if err != nil {
+		return "", err
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s, nil
+}
+
+func (d *snapshotDecoder) float() (float64, error) {
+	if len(d.b) < 8 {
+		return 0, errSnapshotCorrupted
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v, nil
+}
+
+// value reads the value of a key of the given type
+func (d *snapshotDecoder) value(kind byte, key string, now time.Time) (*redisObject, error) {
+	if kind == snapshotString {
+		s, err := d.string()
+		return &redisObject{kind: stringType, value: s}, err
+	}
+	n, err := d.len()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case snapshotList:
+		l := newQuicklist()
+		for i := 0; i < n; i++ {
+			v, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			l.pushTail(v)
+		}
+		return &redisObject{kind: listType, value: l}, nil
+	case snapshotSet, snapshotIntset:
+		s := newSet()
+		for i := 0; i < n; i++ {
+			var member string
+			if kind == snapshotIntset {
+				v, err := d.varint()
+				if err != nil {
+					return nil, err
+				}
+				member = strconv.FormatInt(v, 10)
+			} else if member, err = d.string(); err != nil {
+				return nil, err
+			}
+			s.add(member)
+		}
+		return &redisObject{kind: setType, value: s}, nil
+	case snapshotZset:
+		z := newSortedSet()
+		for i := 0; i < n; i++ {
+			member, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			score, err := d.float()
+			if err != nil {
+				return nil, err
+			}
+			z.insert(score, member)
+		}
+		return &redisObject{kind: zsetType, value: z}, nil
+	case snapshotHash, snapshotHashTTL:
+		h := newHash()
+		for i := 0; i < n; i++ {
+			field, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			var ms int64
+			if kind == snapshotHashTTL {
+				if ms, err = d.varint(); err != nil {
+					return nil, err
+				}
+			}
+			expire := time.UnixMilli(ms)
+			if ms != 0 && !expire.After(now) {
+				continue
+			}
+			h.set(field, value)
+			if ms != 0 {
+				h.setExpire(field, expire)
+				volatileHashes[key] = struct{}{}
+			}
+		}
+		return &redisObject{kind: hashType, value: h}, nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", kind)
+}
+
+// decodeSnapshot replaces the dataset by the content of the snapshot.
+// Keys already expired are skipped. keyspaceLock must be held.
+func decodeSnapshot(b []byte) error {
+	header := len(snapshotMagic) + 4
+	if len(b) < header+9 || string(b[:len(snapshotMagic)]) != snapshotMagic {
+		return errors.New("not a snapshot file")
+	}
+	version, err := strconv.Atoi(string(b[len(snapshotMagic):header]))
+	if err != nil || version > snapshotVersion {
+		return fmt.Errorf("can't handle snapshot format version %s", b[len(snapshotMagic):header])
+	}
+	body := b[:len(b)-8]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(b[len(body):]) {
+		return errors.New("wrong snapshot checksum")
+	}
+
+	now := time.Now()
+	keyspace = map[string]*redisObject{}
+	d := &snapshotDecoder{b: body[header:]}
+	var expire time.Time
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case snapshotOpEOF:
+			if len(d.b) != 0 {
+				return errSnapshotCorrupted
+			}
+			return nil
+		case snapshotOpAux:
+			if _, err := d.string(); err != nil {
+				return err
+			}
+			if _, err := d.string(); err != nil {
+				return err
+			}
+			continue
+		case snapshotOpExpire:
+			ms, err := d.varint()
+			if err != nil {
+				return err
+			}
+			expire = time.UnixMilli(ms)
+			continue
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return err
+		}
+		obj, err := d.value(op, key, now)
+		if err != nil {
+			return err
+		}
+		obj.expire, expire = expire, time.Time{}
+		if obj.isExpired(now) || (obj.kind == hashType && obj.value.(*redisHash).len() == 0) {
+			continue
+		}
+		keyspace[key] = obj
+		if !obj.expire.IsZero() {
+			volatileKeys[key] = struct{}{}
+		}
+	}
+}
+
+// loadSnapshot loads the snapshot file, which may also be an RDB file from
+// Redis. The dataset is left empty when there is none.
+func loadSnapshot(name string) error {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		b, err = unsealFile(b)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	decode := decodeSnapshot
+	if isRdb(b) {
+		decode = decodeRdb
+	}
+	keyspaceLock.Lock()
+	defer keyspaceLock.Unlock()
+	if err := decode(b); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	dirty = 0
+	return nil
+}
+
+// save writes a snapshot in the foreground, keyspaceLock must be held
+func save() error {
+	if saver.saving {
+		return errSaveInProgress
+	}
+	if err := writeSnapshot(encodeSnapshot(), snapshotPath()); err != nil {
+		return err
+	}
+	dirty = 0
+	saver.lastSave = time.Now()
+	return nil
+}
+
+// startBgsave encodes the dataset and writes it in the background,
+// keyspaceLock must be held
+func startBgsave() error {
+	if saver.saving {
+		return errSaveInProgress
+	}
+	saver.saving = true
+	saver.dirtyBeforeSave = dirty
+	saver.lastTry = time.Now()
+	b := encodeSnapshot()
+	go func() {
+		err := writeSnapshot(b, snapshotPath())
+		if err != nil {
+			log.Println("Background saving error:", err)
+		}
+		keyspaceLock.Lock()
+		defer keyspaceLock.Unlock()
+		saver.saving = false
+		saver.lastErr = err
+		if err == nil {
+			// Changes made while the snapshot was written are still to save
+			dirty -= saver.dirtyBeforeSave
+			saver.lastSave = saver.lastTry
+		}
+	}()
+	return nil
+}
+
+// saveRules returns the seconds and changes pairs of the save setting
+func saveRules() [][2]int {
+	value, _ := serverConfig.Get("save")
+	fields := strings.Fields(value)
+	rules := make([][2]int, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, _ := strconv.Atoi(fields[i])
+		changes, _ := strconv.Atoi(fields[i+1])
+		rules = append(rules, [2]int{seconds, changes})
+	}
+	return rules
+}
+
+// snapshotCron starts a BGSAVE once a save rule is met, at least changes
+// changes in the last seconds seconds. keyspaceLock must be held.
+func snapshotCron(now time.Time) {
+	if saver.saving || (saver.lastErr != nil && now.Sub(saver.lastTry) < snapshotRetryDelay) {
+		return
+	}
+	for _, rule := range saveRules() {
+		if dirty >= rule[1] && now.Sub(saver.lastSave) >= time.Duration(rule[0])*time.Second {
+			log.Printf("%d changes in %d seconds. Saving...", rule[1], rule[0])
+			startBgsave()
+			return
+		}
+	}
+}
+
+// SAVE
+func saveCmd(p []resp.Payload) resp.Payload {
+	if len(p) != 0 {
+		return missingArgumentsError
+	}
+	if err := save(); err != nil {
+		return resp.NewError("ERR", err.Error())
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: "OK"}
+}
+
+// BGSAVE [SCHEDULE]
+func bgsave(p []resp.Payload) resp.Payload {
+	if len(p) > 1 || (len(p) == 1 && !strings.EqualFold(string(p[0].Bulk), "SCHEDULE")) {
+		return syntaxError
+	}
+	if err := startBgsave(); err != nil {
+		return resp.NewError("ERR", err.Error())
+	}
+	return resp.Payload{DataType: string(resp.STRING), Str: "Background saving started"}
+}
+
+// LASTSAVE
+func lastsave(p []resp.Payload) resp.Payload {
+	return resp.Payload{DataType: string(resp.INTEGER), Num: int(saver.lastSave.Unix())}
+}
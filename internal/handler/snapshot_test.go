@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useSnapshotPath(t *testing.T) string {
+	old, _ := serverConfig.Get("dir")
+	serverConfig.Set("dir", t.TempDir())
+	t.Cleanup(func() { serverConfig.Set("dir", old) })
+	return snapshotPath()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	aof := newTestAof(t)
+	keyspace = map[string]*redisObject{}
+
+	for i := 0; i < 300; i++ {
+		processRequest(nil, request("RPUSH", "list", strconv.Itoa(i)), aof)
+	}
+	processRequest(nil, request("SET", "str", "value"), aof)
+	processRequest(nil, request("SET", "empty", ""), aof)
+	processRequest(nil, request("SADD", "ints", "1", "-20", "300000"), aof)
+	processRequest(nil, request("SADD", "set", "a", "b", "1"), aof)
+	processRequest(nil, request("ZADD", "zset", "1.5", "a", "-inf", "b", "2e30", "c"), aof)
+	processRequest(nil, request("HSET", "hash", "f1", "v1", "f2", "v2"), aof)
+	processRequest(nil, request("HSET", "volatile-fields", "f1", "v1", "f2", "v2", "f3", "v3"), aof)
+	processRequest(nil, request("HPEXPIRE", "volatile-fields", "100000", "FIELDS", "1", "f1"), aof)
+	processRequest(nil, request("HPEXPIRE", "volatile-fields", "1", "FIELDS", "1", "f2"), aof)
+	processRequest(nil, request("SET", "volatile", "v", "EX", "100"), aof)
+	processRequest(nil, request("SET", "expired", "v", "PX", "1"), aof)
+	time.Sleep(5 * time.Millisecond)
+
+	b := encodeSnapshot()
+	if !strings.HasPrefix(string(b), "REDISLITE0001") {
+		t.Fatalf("Unexpected header %q", b[:13])
+	}
+	delete(keyspace, "expired")
+	lookupKey("volatile-fields")
+	expected := dataset()
+
+	keyspace = map[string]*redisObject{}
+	if err := decodeSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after loading, got %v", expected, got)
+	}
+	if keyspace["ints"].value.(*redisSet).dict != nil {
+		t.Errorf("Expected the intset encoding to be kept")
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	keyspace = map[string]*redisObject{}
+	setKey("key", &redisObject{kind: stringType, value: "value"})
+	b := encodeSnapshot()
+
+	flipped := append([]byte{}, b...)
+	flipped[len(flipped)-12] ^= 1
+	if err := decodeSnapshot(flipped); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+	if err := decodeSnapshot(b[:len(b)-3]); err == nil {
+		t.Errorf("Expected an error on a truncated snapshot")
+	}
+	newer := append([]byte("REDISLITE0002"), b[13:]...)
+	if err := decodeSnapshot(newer); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected a version error, got %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	aof := newTestAof(t)
+	name := useSnapshotPath(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "key", "value"), aof)
+	processRequest(nil, request("HSET", "hash", "f", "v"), aof)
+	expected := dataset()
+	before := saver.lastSave
+	time.Sleep(time.Second - time.Duration(time.Now().Nanosecond()))
+
+	if response := processRequest(nil, request("SAVE"), aof); response.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", response)
+	}
+	if dirty != 0 {
+		t.Errorf("Expected no change since the last save, got %d", dirty)
+	}
+	if response := processRequest(nil, request("LASTSAVE"), aof); int64(response.Num) <= before.Unix() {
+		t.Errorf("Expected LASTSAVE to move after %d, got %d", before.Unix(), response.Num)
+	}
+
+	keyspace = map[string]*redisObject{}
+	if err := loadSnapshot(name); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after loading, got %v", expected, got)
+	}
+
+	os.WriteFile(name, []byte("garbage"), 0666)
+	if err := loadSnapshot(name); err == nil {
+		t.Errorf("Expected an error loading a corrupted snapshot")
+	}
+	if err := loadSnapshot(filepath.Join(t.TempDir(), "missing.snap")); err != nil {
+		t.Errorf("Expected no error without a snapshot, got %v", err)
+	}
+}
+
+func waitBgsave(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		keyspaceLock.Lock()
+		saving := saver.saving
+		keyspaceLock.Unlock()
+		if !saving {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("BGSAVE did not complete")
+}
+
+func TestBgsave(t *testing.T) {
+	aof := newTestAof(t)
+	name := useSnapshotPath(t)
+	keyspace = map[string]*redisObject{}
+
+	processRequest(nil, request("SET", "key", "value"), aof)
+	expected := dataset()
+	if response := processRequest(nil, request("BGSAVE"), aof); response.Str != "Background saving started" {
+		t.Fatalf("Expected the save to start, got %v", response)
+	}
+	if response := processRequest(nil, request("SAVE"), aof); response.ErrorCode() != "ERR" {
+		t.Errorf("Expected SAVE to fail while BGSAVE runs, got %v", response)
+	}
+	// Not in the snapshot, still to save afterwards
+	processRequest(nil, request("SET", "other", "value"), aof)
+	waitBgsave(t)
+	if dirty != 1 {
+		t.Errorf("Expected 1 change since the last save, got %d", dirty)
+	}
+
+	keyspace = map[string]*redisObject{}
+	if err := loadSnapshot(name); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataset(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after loading, got %v", expected, got)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	aof := newTestAof(t)
+	name := useSnapshotPath(t)
+	keyspace = map[string]*redisObject{}
+	defer serverConfig.Set("save", "3600 1 300 100 60 10000")
+	serverConfig.Set("save", "60 3 3600 1")
+	processRequest(nil, request("SAVE"), aof)
+	os.Remove(name)
+
+	processRequest(nil, request("SET", "a", "1"), aof)
+	processRequest(nil, request("SET", "b", "1"), aof)
+	keyspaceLock.Lock()
+	snapshotCron(saver.lastSave.Add(time.Minute))
+	keyspaceLock.Unlock()
+	waitBgsave(t)
+	if _, err := os.Stat(name); err == nil {
+		t.Fatalf("Expected no snapshot before a save rule is met")
+	}
+
+	processRequest(nil, request("SET", "c", "1"), aof)
+	keyspaceLock.Lock()
+	snapshotCron(saver.lastSave.Add(time.Minute))
+	keyspaceLock.Unlock()
+	waitBgsave(t)
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("Expected a snapshot once 3 changes were made in 60 seconds: %v", err)
+	}
+
+	serverConfig.Set("save", "")
+	processRequest(nil, request("SET", "d", "1"), aof)
+	keyspaceLock.Lock()
+	snapshotCron(saver.lastSave.Add(time.Hour))
+	keyspaceLock.Unlock()
+	if saver.saving || dirty != 1 {
+		t.Errorf("Expected no snapshot without save rules")
+	}
+}
@@ -0,0 +1,798 @@
+package handler
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+// Sorted sets are stored as a skiplist ordered by score plus a map from
+// member to score for O(1) lookups.
+
+type sortedSet struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{dict: map[string]float64{}, zsl: newZskiplist()}
+}
+
+func (z *sortedSet) len() int {
+	return len(z.dict)
+}
+
+func (z *sortedSet) insert(score float64, member string) {
+	z.dict[member] = score
+	z.zsl.insert(score, member)
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// rank returns the 0-based rank of member, or -1 if it does not exist
+func (z *sortedSet) rank(member string, reverse bool) int {
+	score, ok := z.dict[member]
+	if !ok {
+		return -1
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.len() - rank
+	}
+	return rank - 1
+}
+
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddINCR
+)
+
+type zaddResult int
+
+const (
+	zaddNop zaddResult = iota
+	zaddSkipped
+	zaddAdded
+	zaddUpdated
+	zaddNaN
+)
+
+// add inserts or updates member following the ZADD flags. The new score is
+// returned unless the member was skipped because of the flags.
+func (z *sortedSet) add(score float64, member string, flags int) (float64, zaddResult) {
+	cur, exists := z.dict[member]
+	if !exists {
+		if flags&zaddXX != 0 {
+			return 0, zaddSkipped
+		}
+		z.insert(score, member)
+		return score, zaddAdded
+	}
+
+	if flags&zaddNX != 0 {
+		return 0, zaddSkipped
+	}
+	if flags&zaddINCR != 0 {
+		score += cur
+		if math.IsNaN(score) {
+			return 0, zaddNaN
+		}
+	}
+	if (flags&zaddLT != 0 && score >= cur) || (flags&zaddGT != 0 && score <= cur) {
+		return 0, zaddSkipped
+	}
+	if score == cur {
+		return score, zaddNop
+	}
+	z.zsl.delete(cur, member)
+	z.insert(score, member)
+	return score, zaddUpdated
+}
+
+func parseScore(s string) (float64, bool) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+var notFloatError = resp.NewError("ERR", "value is not a valid float")
+var nanScoreError = resp.NewError("ERR", "resulting score is not a number (NaN)")
+
+// lookupSortedSet returns the sorted set at key, or nil when it does not exist
+func lookupSortedSet(key string) (*sortedSet, bool) {
+	obj, ok := lookupTyped(key, zsetType)
+	if !ok || obj == nil {
+		return nil, ok
+	}
+	return obj.value.(*sortedSet), true
+}
+
+// scorePayload replies with a double, sent as a bulk string to RESP2 clients
+func scorePayload(score float64) resp.Payload {
+	return resp.Payload{DataType: string(resp.DOUBLE), Double: score}
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zadd(p []resp.Payload) resp.Payload {
+	if len(p) < 3 {
+		return missingArgumentsError
+	}
+	key := string(p[0].Bulk)
+	var flags int
+	var ch bool
+	i := 1
+options:
+	for ; i < len(p); i++ {
+		switch strings.ToUpper(string(p[i].Bulk)) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			ch = true
+		case "INCR":
+			flags |= zaddINCR
+		default:
+			break options
+		}
+	}
+
+	pairs := p[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return syntaxError
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return resp.NewError("ERR", "XX and NX options at the same time are not compatible")
+	}
+	if (flags&zaddGT != 0 && flags&zaddLT != 0) || (flags&zaddNX != 0 && flags&(zaddGT|zaddLT) != 0) {
+		return resp.NewError("ERR", "GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags&zaddINCR != 0 && len(pairs) > 2 {
+		return resp.NewError("ERR", "INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(string(pairs[j].Bulk))
+		if !ok {
+			return notFloatError
+		}
+		scores = append(scores, score)
+	}
+
+	obj, ok := lookupTyped(key, zsetType)
+	if !ok {
+		return wrongTypeError
+	}
+	if obj == nil {
+		if flags&zaddXX != 0 {
+			if flags&zaddINCR != 0 {
+				return resp.NullBulk
+			}
+			return resp.Payload{DataType: string(resp.INTEGER), Num: 0}
+		}
+		obj = &redisObject{kind: zsetType, value: newSortedSet()}
+		setKey(key, obj)
+	}
+	z := obj.value.(*sortedSet)
+
+	var added, updated int
+	var lastScore float64
+	var lastResult zaddResult
+	for j, score := range scores {
+		lastScore, lastResult = z.add(score, string(pairs[2*j+1].Bulk), flags)
+		switch lastResult {
+		case zaddAdded:
+			added++
+		case zaddUpdated:
+			updated++
+		case zaddNaN:
+			return nanScoreError
+		}
+	}
+	dirty += added + updated
+
+	if flags&zaddINCR != 0 {
+		if lastResult == zaddSkipped {
+			return resp.NullBulk
+		}
+		return scorePayload(lastScore)
+	}
+	if ch {
+		return resp.Payload{DataType: string(resp.INTEGER), Num: added + updated}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: added}
+}
+
+// ZINCRBY key increment member
+func zincrby(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	return zadd([]resp.Payload{p[0], {Bulk: []byte("INCR")}, p[1], p[2]})
+}
+
+func zrem(p []resp.Payload) resp.Payload {
+	if len(p) < 2 {
+		return missingArgumentsError
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var removed int
+	if z != nil {
+		for _, m := range p[1:] {
+			if z.remove(string(m.Bulk)) {
+				removed++
+			}
+		}
+		dirty += removed
+		if z.len() == 0 {
+			delete(keyspace, string(p[0].Bulk))
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: removed}
+}
+
+func zcard(p []resp.Payload) resp.Payload {
+	if len(p) != 1 {
+		return missingArgumentsError
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var card int
+	if z != nil {
+		card = z.len()
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: card}
+}
+
+func zscore(p []resp.Payload) resp.Payload {
+	if len(p) != 2 {
+		return missingArgumentsError
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if z == nil {
+		return resp.NullBulk
+	}
+	score, exists := z.dict[string(p[1].Bulk)]
+	if !exists {
+		return resp.NullBulk
+	}
+	return scorePayload(score)
+}
+
+// ZRANK key member [WITHSCORE]
+func zrankGeneric(p []resp.Payload, reverse bool) resp.Payload {
+	if len(p) != 2 && len(p) != 3 {
+		return missingArgumentsError
+	}
+	withScore := len(p) == 3
+	if withScore && strings.ToUpper(string(p[2].Bulk)) != "WITHSCORE" {
+		return syntaxError
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if z == nil {
+		return resp.NullBulk
+	}
+	rank := z.rank(string(p[1].Bulk), reverse)
+	if rank == -1 {
+		return resp.NullBulk
+	}
+	if withScore {
+		return resp.Payload{DataType: string(resp.ARRAY), Array: []resp.Payload{
+			{DataType: string(resp.INTEGER), Num: rank},
+			scorePayload(z.dict[string(p[1].Bulk)]),
+		}}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: rank}
+}
+
+func zrank(p []resp.Payload) resp.Payload    { return zrankGeneric(p, false) }
+func zrevrank(p []resp.Payload) resp.Payload { return zrankGeneric(p, true) }
+
+// parseScoreBound parses a score range bound, "(" makes it exclusive
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	v, ok := parseScore(s)
+	return v, exclusive, ok
+}
+
+func parseScoreRange(min, max string) (*zrangespec, resp.Payload) {
+	var r zrangespec
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseScoreBound(min)
+	r.max, r.maxex, ok2 = parseScoreBound(max)
+	if !ok1 || !ok2 {
+		return nil, resp.NewError("ERR", "min or max is not a float")
+	}
+	return &r, resp.Payload{}
+}
+
+func parseLexBound(s string) (lexBound, bool) {
+	switch {
+	case s == "-":
+		return lexBound{inf: -1}, true
+	case s == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return lexBound{value: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return lexBound{value: s[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+func parseLexRange(min, max string) (*zlexrangespec, resp.Payload) {
+	var r zlexrangespec
+	var ok1, ok2 bool
+	r.min, ok1 = parseLexBound(min)
+	r.max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return nil, resp.NewError("ERR", "min or max not valid string range item")
+	}
+	return &r, resp.Payload{}
+}
+
+func zcount(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	r, errPayload := parseScoreRange(string(p[1].Bulk), string(p[2].Bulk))
+	if r == nil {
+		return errPayload
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var count int
+	if z != nil {
+		if first := z.zsl.firstInRange(r); first != nil {
+			last := z.zsl.lastInRange(r)
+			count = z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
+}
+
+func zlexcount(p []resp.Payload) resp.Payload {
+	if len(p) != 3 {
+		return missingArgumentsError
+	}
+	r, errPayload := parseLexRange(string(p[1].Bulk), string(p[2].Bulk))
+	if r == nil {
+		return errPayload
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	var count int
+	if z != nil {
+		if first := z.zsl.firstInLexRange(r); first != nil {
+			last := z.zsl.lastInLexRange(r)
+			count = z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+		}
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: count}
+}
+
+// zrangeReply accumulates the members of a range reply
+type zrangeReply struct {
+	withScores bool
+	array      []resp.Payload
+}
+
+func (r *zrangeReply) add(n *zskiplistNode) {
+	r.array = append(r.array, resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(n.member)})
+	if r.withScores {
+		r.array = append(r.array, scorePayload(n.score))
+	}
+}
+
+func (r *zrangeReply) payload() resp.Payload {
+	if r.array == nil {
+		r.array = []resp.Payload{}
+	}
+	return resp.Payload{DataType: string(resp.ARRAY), Array: r.array}
+}
+
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zrange(p []resp.Payload) resp.Payload {
+	if len(p) < 3 {
+		return missingArgumentsError
+	}
+	var byScore, byLex, rev, limit bool
+	reply := &zrangeReply{}
+	offset, count := 0, -1
+	for i := 3; i < len(p); i++ {
+		switch strings.ToUpper(string(p[i].Bulk)) {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			reply.withScores = true
+		case "LIMIT":
+			if i+2 >= len(p) {
+				return syntaxError
+			}
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(string(p[i+1].Bulk))
+			count, err2 = strconv.Atoi(string(p[i+2].Bulk))
+			if err1 != nil || err2 != nil {
+				return notIntegerError
+			}
+			limit = true
+			i += 2
+		default:
+			return syntaxError
+		}
+	}
+	if byScore && byLex {
+		return syntaxError
+	}
+	if limit && !byScore && !byLex {
+		return resp.NewError("ERR", "syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if byLex && reply.withScores {
+		return resp.NewError("ERR", "syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// With REV the range is given from max to min
+	start, stop := string(p[1].Bulk), string(p[2].Bulk)
+	if rev && (byScore || byLex) {
+		start, stop = stop, start
+	}
+
+	var scoreRange *zrangespec
+	var lexRange *zlexrangespec
+	var errPayload resp.Payload
+	switch {
+	case byScore:
+		scoreRange, errPayload = parseScoreRange(start, stop)
+		if scoreRange == nil {
+			return errPayload
+		}
+	case byLex:
+		lexRange, errPayload = parseLexRange(start, stop)
+		if lexRange == nil {
+			return errPayload
+		}
+	}
+
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	if z == nil {
+		return reply.payload()
+	}
+
+	switch {
+	case byScore:
+		zrangeByScore(z, scoreRange, rev, offset, count, reply)
+	case byLex:
+		zrangeByLex(z, lexRange, rev, offset, count, reply)
+	default:
+		startRank, err1 := strconv.Atoi(start)
+		stopRank, err2 := strconv.Atoi(stop)
+		if err1 != nil || err2 != nil {
+			return notIntegerError
+		}
+		zrangeByRank(z, startRank, stopRank, rev, reply)
+	}
+	return reply.payload()
+}
+
+func zrangeByRank(z *sortedSet, start, stop int, rev bool, reply *zrangeReply) {
+	length := z.len()
+	start = max(normalizeIndex(start, length), 0)
+	stop = min(normalizeIndex(stop, length), length-1)
+	if start > stop {
+		return
+	}
+	var n *zskiplistNode
+	if rev {
+		n = z.zsl.byRank(length - start)
+	} else {
+		n = z.zsl.byRank(start + 1)
+	}
+	for i := start; i <= stop; i++ {
+		reply.add(n)
+		n = zslNext(n, rev)
+	}
+}
+
+func zslNext(n *zskiplistNode, rev bool) *zskiplistNode {
+	if rev {
+		return n.backward
+	}
+	return n.level[0].forward
+}
+
+func zrangeByScore(z *sortedSet, r *zrangespec, rev bool, offset, count int, reply *zrangeReply) {
+	if offset < 0 {
+		return
+	}
+	var n *zskiplistNode
+	if rev {
+		n = z.zsl.lastInRange(r)
+	} else {
+		n = z.zsl.firstInRange(r)
+	}
+	// A negative count returns all the elements after offset
+	for ; n != nil && count != 0; n = zslNext(n, rev) {
+		if rev && !r.gteMin(n.score) || !rev && !r.lteMax(n.score) {
+			break
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		reply.add(n)
+		count--
+	}
+}
+
+func zrangeByLex(z *sortedSet, r *zlexrangespec, rev bool, offset, count int, reply *zrangeReply) {
+	if offset < 0 {
+		return
+	}
+	var n *zskiplistNode
+	if rev {
+		n = z.zsl.lastInLexRange(r)
+	} else {
+		n = z.zsl.firstInLexRange(r)
+	}
+	// A negative count returns all the elements after offset
+	for ; n != nil && count != 0; n = zslNext(n, rev) {
+		if rev && !r.gteMin(n.member) || !rev && !r.lteMax(n.member) {
+			break
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		reply.add(n)
+		count--
+	}
+}
+
+// zrangeWith calls ZRANGE with the given options inserted after the range
+func zrangeWith(p []resp.Payload, options ...string) resp.Payload {
+	if len(p) < 3 {
+		return missingArgumentsError
+	}
+	args := append([]resp.Payload{}, p[:3]...)
+	for _, o := range options {
+		args = append(args, resp.Payload{DataType: string(resp.BULKSTRING), Bulk: []byte(o)})
+	}
+	return zrange(append(args, p[3:]...))
+}
+
+func zrevrange(p []resp.Payload) resp.Payload        { return zrangeWith(p, "REV") }
+func zrangebyscore(p []resp.Payload) resp.Payload    { return zrangeWith(p, "BYSCORE") }
+func zrevrangebyscore(p []resp.Payload) resp.Payload { return zrangeWith(p, "BYSCORE", "REV") }
+func zrangebylex(p []resp.Payload) resp.Payload      { return zrangeWith(p, "BYLEX") }
+func zrevrangebylex(p []resp.Payload) resp.Payload   { return zrangeWith(p, "BYLEX", "REV") }
+
+// ZPOPMIN key [count]
+func zpop(p []resp.Payload, fromMax bool) resp.Payload {
+	if len(p) != 1 && len(p) != 2 {
+		return missingArgumentsError
+	}
+	count := 1
+	if len(p) == 2 {
+		n, err := strconv.Atoi(string(p[1].Bulk))
+		if err != nil || n < 0 {
+			return resp.NewError("ERR", "value is out of range, must be positive")
+		}
+		count = n
+	}
+	z, ok := lookupSortedSet(string(p[0].Bulk))
+	if !ok {
+		return wrongTypeError
+	}
+	reply := &zrangeReply{withScores: true}
+	if z == nil {
+		return reply.payload()
+	}
+	for ; count > 0 && z.len() > 0; count-- {
+		n := z.zsl.header.level[0].forward
+		if fromMax {
+			n = z.zsl.tail
+		}
+		reply.add(n)
+		z.remove(n.member)
+		dirty++
+	}
+	if z.len() == 0 {
+		delete(keyspace, string(p[0].Bulk))
+	}
+	return reply.payload()
+}
+
+func zpopmin(p []resp.Payload) resp.Payload { return zpop(p, false) }
+func zpopmax(p []resp.Payload) resp.Payload { return zpop(p, true) }
+
+// zsetInput gives a uniform view of the sets and sorted sets given to
+// ZUNIONSTORE and ZINTERSTORE, members of plain sets have a score of 1
+type zsetInput struct {
+	scores map[string]float64
+	weight float64
+}
+
+func lookupZsetInput(key string) (map[string]float64, bool) {
+	obj := lookupKey(key)
+	if obj == nil {
+		return map[string]float64{}, true
+	}
+	switch obj.kind {
+	case zsetType:
+		return obj.value.(*sortedSet).dict, true
+	case setType:
+		scores := map[string]float64{}
+		for _, m := range obj.value.(*redisSet).members() {
+			scores[m] = 1
+		}
+		return scores, true
+	}
+	return nil, false
+}
+
+func aggregateScores(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "MIN":
+		return min(a, b)
+	case "MAX":
+		return max(a, b)
+	}
+	sum := a + b
+	// inf + -inf
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+func weightedScore(score, weight float64) float64 {
+	v := score * weight
+	// inf * 0
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func zsetStore(p []resp.Payload, union bool) resp.Payload {
+	if len(p) < 3 {
+		return missingArgumentsError
+	}
+	numkeys, err := strconv.Atoi(string(p[1].Bulk))
+	if err != nil || numkeys <= 0 {
+		return resp.NewError("ERR", "at least 1 input key is needed for this command")
+	}
+	if numkeys > len(p)-2 {
+		return syntaxError
+	}
+	inputs := make([]zsetInput, numkeys)
+	for i := range inputs {
+		inputs[i].weight = 1
+	}
+	aggregate := "SUM"
+	rest := p[numkeys+2:]
+	for i := 0; i < len(rest); i++ {
+		switch strings.ToUpper(string(rest[i].Bulk)) {
+		case "WEIGHTS":
+			if i+numkeys >= len(rest) {
+				return syntaxError
+			}
+			for j := range inputs {
+				w, ok := parseScore(string(rest[i+1+j].Bulk))
+				if !ok {
+					return resp.NewError("ERR", "weight value is not a float")
+				}
+				inputs[j].weight = w
+			}
+			i += numkeys
+		case "AGGREGATE":
+			if i+1 >= len(rest) {
+				return syntaxError
+			}
+			aggregate = strings.ToUpper(string(rest[i+1].Bulk))
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return syntaxError
+			}
+			i++
+		default:
+			return syntaxError
+		}
+	}
+
+	for i, k := range p[2 : numkeys+2] {
+		scores, ok := lookupZsetInput(string(k.Bulk))
+		if !ok {
+			return wrongTypeError
+		}
+		inputs[i].scores = scores
+	}
+
+	result := map[string]float64{}
+	if union {
+		for _, in := range inputs {
+			for m, s := range in.scores {
+				score := weightedScore(s, in.weight)
+				if cur, ok := result[m]; ok {
+					score = aggregateScores(aggregate, cur, score)
+				}
+				result[m] = score
+			}
+		}
+	} else {
+		slices.SortFunc(inputs, func(a, b zsetInput) int { return len(a.scores) - len(b.scores) })
+		for m, s := range inputs[0].scores {
+			score := weightedScore(s, inputs[0].weight)
+			inAll := true
+			for _, in := range inputs[1:] {
+				other, ok := in.scores[m]
+				if !ok {
+					inAll = false
+					break
+				}
+				score = aggregateScores(aggregate, score, weightedScore(other, in.weight))
+			}
+			if inAll {
+				result[m] = score
+			}
+		}
+	}
+
+	deleteKey(string(p[0].Bulk))
+	if len(result) > 0 {
+		z := newSortedSet()
+		for m, s := range result {
+			z.insert(s, m)
+		}
+		setKey(string(p[0].Bulk), &redisObject{kind: zsetType, value: z})
+	}
+	return resp.Payload{DataType: string(resp.INTEGER), Num: len(result)}
+}
+
+func zunionstore(p []resp.Payload) resp.Payload { return zsetStore(p, true) }
+func zinterstore(p []resp.Payload) resp.Payload { return zsetStore(p, false) }
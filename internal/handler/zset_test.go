@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ger/redis-lite-go/internal/resp"
+)
+
+func TestZadd(t *testing.T) {
+	del(args("zset"))
+
+	if response := zadd(args("zset", "1", "a", "2", "b", "3", "c")); response.Num != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	if response := zadd(args("zset", "NX", "10", "a", "4", "d")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := zadd(args("zset", "XX", "CH", "5", "a", "5", "e")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := zadd(args("zset", "GT", "CH", "1", "b", "6", "c")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := zadd(args("zset", "INCR", "1.5", "b")); response.Double != 3.5 {
+		t.Errorf("Expected 3.5, got %v", response)
+	}
+	if response := zadd(args("zset", "LT", "INCR", "1", "b")); !response.Null {
+		t.Errorf("Expected nil, got %v", response)
+	}
+	if response := zincrby(args("zset", "-1", "d")); response.Double != 3 {
+		t.Errorf("Expected 3, got %v", response)
+	}
+	for _, bad := range [][]string{
+		{"zset", "NX", "XX", "1", "a"},
+		{"zset", "GT", "LT", "1", "a"},
+		{"zset", "INCR", "1", "a", "2", "b"},
+		{"zset", "x", "a"},
+		{"zset", "1"},
+	} {
+		if response := zadd(args(bad...)); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected error for %v, got %v", bad, response)
+		}
+	}
+
+	// a:5 b:3.5 c:6 d:3
+	if got := bulks(zrange(args("zset", "0", "-1", "WITHSCORES"))); !reflect.DeepEqual(got, []string{"d", "3", "b", "3.5", "a", "5", "c", "6"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+	if response := zrank(args("zset", "a")); response.Num != 2 {
+		t.Errorf("Expected 2, got %v", response)
+	}
+	if response := zrevrank(args("zset", "a")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if response := zscore(args("zset", "b")); response.Double != 3.5 {
+		t.Errorf("Expected 3.5, got %v", response)
+	}
+	if response := zrem(args("zset", "a", "z")); response.Num != 1 || zcard(args("zset")).Num != 3 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+}
+
+func TestZrange(t *testing.T) {
+	del(args("zset", "lex"))
+	zadd(args("zset", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"))
+	zadd(args("lex", "0", "a", "0", "b", "0", "c", "0", "d"))
+
+	tests := []struct {
+		cmd      func([]resp.Payload) resp.Payload
+		args     []string
+		expected []string
+	}{
+		{zrange, []string{"zset", "1", "-2"}, []string{"b", "c", "d"}},
+		{zrange, []string{"zset", "0", "1", "REV"}, []string{"e", "d"}},
+		{zrange, []string{"zset", "(1", "4", "BYSCORE", "LIMIT", "1", "2"}, []string{"c", "d"}},
+		{zrange, []string{"zset", "+inf", "(3", "BYSCORE", "REV"}, []string{"e", "d"}},
+		{zrange, []string{"lex", "[b", "(d", "BYLEX"}, []string{"b", "c"}},
+		{zrange, []string{"lex", "+", "-", "BYLEX", "REV", "LIMIT", "0", "2"}, []string{"d", "c"}},
+		{zrangebyscore, []string{"zset", "-inf", "2", "WITHSCORES"}, []string{"a", "1", "b", "2"}},
+		{zrevrangebyscore, []string{"zset", "5", "4"}, []string{"e", "d"}},
+		{zrangebylex, []string{"lex", "-", "[a"}, []string{"a"}},
+		{zrevrange, []string{"zset", "0", "0"}, []string{"e"}},
+		{zrange, []string{"nonexisting", "0", "-1"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := bulks(tt.cmd(args(tt.args...))); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.args, tt.expected, got)
+		}
+	}
+
+	if response := zrange(args("zset", "0", "1", "LIMIT", "0", "1")); response.DataType != string(resp.ERROR) {
+		t.Errorf("Expected error, got %v", response)
+	}
+	if response := zcount(args("zset", "(1", "+inf")); response.Num != 4 {
+		t.Errorf("Expected 4, got %v", response)
+	}
+	if response := zlexcount(args("lex", "(a", "[c")); response.Num != 2 {
+		t.Errorf("Expected 2, got %v", response)
+	}
+}
+
+func TestZpopAndStore(t *testing.T) {
+	del(args("z1", "z2", "s", "dst"))
+	zadd(args("z1", "1", "a", "2", "b", "3", "c"))
+	zadd(args("z2", "10", "b", "20", "c", "30", "d"))
+	sadd(args("s", "c", "d"))
+
+	if got := bulks(zpopmin(args("z1"))); !reflect.DeepEqual(got, []string{"a", "1"}) {
+		t.Errorf("Unexpected pop %v", got)
+	}
+	if got := bulks(zpopmax(args("z1", "1"))); !reflect.DeepEqual(got, []string{"c", "3"}) {
+		t.Errorf("Unexpected pop %v", got)
+	}
+	zadd(args("z1", "1", "a", "3", "c"))
+
+	if response := zunionstore(args("dst", "2", "z1", "z2", "WEIGHTS", "1", "2")); response.Num != 4 {
+		t.Errorf("Expected 4, got %v", response)
+	}
+	if got := bulks(zrange(args("dst", "0", "-1", "WITHSCORES"))); !reflect.DeepEqual(got, []string{"a", "1", "b", "22", "c", "43", "d", "60"}) {
+		t.Errorf("Unexpected union %v", got)
+	}
+	for _, numkeys := range []string{"3", "9223372036854775807", "9223372036854775806"} {
+		if response := zunionstore(args("dst", numkeys, "z1", "z2")); response.DataType != string(resp.ERROR) {
+			t.Errorf("Expected an error for numkeys %s, got %v", numkeys, response)
+		}
+	}
+	if response := zinterstore(args("dst", "3", "z1", "z2", "s", "AGGREGATE", "MAX")); response.Num != 1 {
+		t.Errorf("Expected 1, got %v", response)
+	}
+	if got := bulks(zrange(args("dst", "0", "-1", "WITHSCORES"))); !reflect.DeepEqual(got, []string{"c", "20"}) {
+		t.Errorf("Unexpected inter %v", got)
+	}
+	zinterstore(args("dst", "2", "z1", "nonexisting"))
+	if exist(args("dst")).Num != 0 {
+		t.Errorf("Expected empty result to delete destination")
+	}
+}
@@ -0,0 +1,169 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+)
+
+// Inline commands, sent by telnet users and health checks as a plain line
+// like PING\r\n. Arguments are separated by spaces and may be quoted the way
+// redis-cli does it.
+
+// isTypeByte reports whether b starts a RESP payload
+func isTypeByte(b byte) bool {
+	switch b {
+	case STRING, ERROR, INTEGER, BULKSTRING, ARRAY,
+		NULL, BOOLEAN, DOUBLE, BIGNUMBER, VERBATIM, MAP, SET, ATTRIBUTE, PUSH:
+		return true
+	}
+	return false
+}
+
+// ReadCommand reads the next command. Commands are usually arrays of bulk
+// strings, a line that does not start with a RESP type byte is an inline
+// command and is returned as an array of bulk strings too. Empty lines are
+// skipped.
+func (r *RespReader) ReadCommand() (Payload, error) {
+	for {
+		b, err := r.reader.Peek(1)
+		if err != nil {
+			return Payload{}, err
+		}
+		if isTypeByte(b[0]) {
+			return r.Read()
+		}
+		p, err := r.readInline()
+		if err != nil || len(p.Array) > 0 {
+			return p, err
+		}
+	}
+}
+
+// readInline reads a line terminated by \n or \r\n and splits it into
+// arguments
+func (r *RespReader) readInline() (Payload, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return Payload{}, errors.New("too big inline request")
+		}
+		return Payload{}, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	args, err := SplitArgs(string(line))
+	if err != nil {
+		return Payload{}, err
+	}
+	p := Payload{DataType: string(ARRAY), Array: make([]Payload, len(args))}
+	for i, arg := range args {
+		p.Array[i] = Payload{DataType: string(BULKSTRING), Bulk: []byte(arg)}
+	}
+	return p, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// SplitArgs splits a line into arguments following the rules of sdssplitargs
+// in Redis. In double quotes \n, \r, \t, \b, \a and \xHH are unescaped and a
+// backslash keeps the next character as is, in single quotes only \' is
+// unescaped. A closing quote must be followed by a space or the end of the
+// line.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var current []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes in request")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					v, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(v))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes in request")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current = append(current, '\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			default:
+				if i == len(line) {
+					done = true
+					continue
+				}
+				switch c := line[i]; c {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
@@ -0,0 +1,51 @@
+package resp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  SET  key\tvalue ", []string{"SET", "key", "value"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key "a\"b\\c\n\x41\x4g"`, []string{"SET", "key", "a\"b\\c\nAx4g"}},
+		{`SET key 'it\'s "raw" \n'`, []string{"SET", "key", `it's "raw" \n`}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+		{`SET k"e y" v`, []string{"SET", "ke y", "v"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		args, err := SplitArgs(tt.line)
+		require.NoError(t, err, tt.line)
+		require.Equal(t, tt.expected, args, tt.line)
+	}
+
+	for _, line := range []string{`SET "key`, `SET 'key`, `SET "key"value`, `SET 'key'value`} {
+		_, err := SplitArgs(line)
+		require.Error(t, err, line)
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	reader := NewRespReader(strings.NewReader("PING\r\n\r\nECHO \"a b\"\n*1\r\n$4\r\nPING\r\nGET 'x\r\n"))
+
+	for _, expected := range [][]string{{"PING"}, {"ECHO", "a b"}, {"PING"}} {
+		p, err := reader.ReadCommand()
+		require.NoError(t, err)
+		require.Equal(t, string(ARRAY), p.DataType)
+		args := make([]string, len(p.Array))
+		for i := range p.Array {
+			args[i] = string(p.Array[i].Bulk)
+		}
+		require.Equal(t, expected, args)
+	}
+	_, err := reader.ReadCommand()
+	require.Error(t, err)
+}
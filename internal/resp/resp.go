@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -20,38 +21,96 @@ const (
 )
 
 type Payload struct {
-	DataType string
-	Str      string
-	Num      int
-	Bulk     string
-	Array    []Payload
+	DataType   string
+	Str        string
+	Num        int
+	Bulk       []byte
+	Array      []Payload
+	Bool       bool
+	Double     float64
+	Attributes []Payload
+	// Null marks the null bulk string $-1 and the null array *-1, both sent as
+	// _ with RESP3
+	Null bool
 }
 
-var NilValue = Payload{DataType: string(ARRAY), Bulk: "-1"}
-var NilPayload = Payload{}
+var NullBulk = Payload{DataType: string(BULKSTRING), Null: true}
+var NullArray = Payload{DataType: string(ARRAY), Null: true}
+
+// NewError returns an error reply. The code is the first word of the message,
+// ERR for generic errors or a specific one like WRONGTYPE, which clients use
+// to tell errors apart.
+func NewError(code, message string) Payload {
+	return Payload{DataType: string(ERROR), Str: code + " " + message}
+}
+
+// ErrorCode returns the code of an error reply, the first word of its message
+func (p *Payload) ErrorCode() string {
+	code, _, _ := strings.Cut(p.Str, " ")
+	return code
+}
+
+// Default size limit of a bulk string, as proto-max-bulk-len in Redis
+const DefaultMaxBulkLen = 512 * 1024 * 1024
 
 type RespReader struct {
-	reader bufio.Reader
+	reader     bufio.Reader
+	maxBulkLen int64
 }
 
 type RespWriter struct {
 	writer bufio.Writer
+	proto  int
 }
 
 func NewRespReader(rd io.Reader) *RespReader {
-	return &RespReader{reader: *bufio.NewReader(rd)}
+	return &RespReader{reader: *bufio.NewReader(rd), maxBulkLen: DefaultMaxBulkLen}
+}
+
+// SetMaxBulkLen sets the size above which bulk strings are rejected
+func (r *RespReader) SetMaxBulkLen(n int64) {
+	r.maxBulkLen = n
 }
 
+// Size of the buffer of a RespWriter, replies are flushed at the latest when
+// it is full
+const writeBufferSize = 16 * 1024
+
 func NewRespWriter(wr io.Writer) *RespWriter {
-	return &RespWriter{writer: *bufio.NewWriter(wr)}
+	return &RespWriter{writer: *bufio.NewWriterSize(wr, writeBufferSize), proto: 2}
 }
 
+// SetProtocol sets the version of the protocol replies are written with.
+// With version 2, RESP3 types are converted to their RESP2 equivalent.
+func (w *RespWriter) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Buffered returns the number of bytes already received and not read yet,
+// when it is not zero more pipelined commands are waiting
+func (r *RespReader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// Peek returns the next n bytes without consuming them, waiting for them to
+// be available
+func (r *RespReader) Peek(n int) ([]byte, error) {
+	return r.reader.Peek(n)
+}
+
+// truncatedError is returned when the input ends in the middle of a payload,
+// errors.Is tells it from other errors with io.ErrUnexpectedEOF
+type truncatedError struct {
+	msg string
+}
+
+func (e truncatedError) Error() string { return e.msg }
+func (e truncatedError) Unwrap() error { return io.ErrUnexpectedEOF }
+
 // Parse payload that follows RESP protocol into payload struct
 // Array of Bulk strings is expected
 func (r *RespReader) Read() (Payload, error) {
 
-	// debug := make([]byte, 1024)
-	// n, _ := r.reader.Read(debug)
 	firstByte, err := r.reader.ReadByte()
 	if err != nil {
 		if err != io.EOF {
@@ -59,7 +118,6 @@ func (r *RespReader) Read() (Payload, error) {
 		}
 		return Payload{}, err
 	}
-	fmt.Printf("first byte : %q\n", firstByte)
 	switch firstByte {
 	case ARRAY:
 		return r.readArray()
@@ -71,29 +129,81 @@ func (r *RespReader) Read() (Payload, error) {
 		return r.readError()
 	case INTEGER:
 		return r.readInteger()
+	case NULL:
+		if _, err := r.readLine(); err != nil {
+			return Payload{}, errors.New("wrong payload format. unable to parse null")
+		}
+		return Payload{DataType: string(NULL)}, nil
+	case BOOLEAN:
+		return r.readBoolean()
+	case DOUBLE:
+		return r.readDouble()
+	case BIGNUMBER:
+		return r.readBigNumber()
+	case VERBATIM:
+		return r.readVerbatim()
+	case MAP:
+		return r.readAggregate(MAP, true)
+	case SET, PUSH:
+		return r.readAggregate(firstByte, false)
+	case ATTRIBUTE:
+		return r.readAttribute()
 	default:
 		err := fmt.Errorf("unexpected first byte of payload : %q", firstByte)
 		return Payload{}, err
 	}
 }
 
+// readLine returns the line without the trailing \r\n. Lines are limited to
+// the size of the buffer, only values of bulk strings can be larger.
+func (r *RespReader) readLine() ([]byte, error) {
+	b, err := r.reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("wrong payload format. line is too long")
+		}
+		return nil, err
+	}
+	if len(b) < 2 || b[len(b)-2] != '\r' {
+		return nil, errors.New("wrong payload format. line is not terminated by CRLF")
+	}
+	return b[:len(b)-2], nil
+}
+
+func (r *RespReader) readSize() (int64, error) {
+	b, err := r.readLine()
+	if err == io.EOF {
+		return 0, truncatedError{"wrong payload format. unable to parse size"}
+	}
+	if err != nil {
+		return 0, errors.New("wrong payload format. unable to parse size")
+	}
+	size, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || size < -1 {
+		return 0, errors.New("wrong payload format. invalid size")
+	}
+	return size, nil
+}
+
 // Expected format 2\r\n<payload>\r\n<payload>\r\n
 func (r *RespReader) readArray() (Payload, error) {
 
 	p := Payload{}
-	b, _, err := r.reader.ReadLine()
+	size, err := r.readSize()
 	if err != nil {
-		return Payload{}, errors.New("wrong payload format. unable to parse size")
+		return p, err
 	}
-	size, _ := strconv.ParseInt(string(b), 10, 64)
 	// Null value is represented as "*-1\r\n"
 	if size == -1 {
-		return p, nil
+		return NullArray, nil
 	}
 
 	p.Array = make([]Payload, 0)
 	for i := 0; i < int(size); i++ {
 		payload, err := r.Read()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return p, err
 		}
@@ -103,35 +213,66 @@ func (r *RespReader) readArray() (Payload, error) {
 	return p, nil
 }
 
-// Expected format $\r\n<payload>\r\n
+// Expected format $<size>\r\n<payload>\r\n
+// The payload is read as is, so it may contain any byte including \r\n
 func (r *RespReader) readBulkString() (Payload, error) {
 
 	p := Payload{}
-	b, _, err := r.reader.ReadLine()
+	size, err := r.readSize()
 	if err != nil {
-		return p, errors.New("wrong payload format. unable to parse size")
+		return p, err
 	}
-	size, _ := strconv.ParseInt(string(b), 10, 64)
 	// Null value is represented as "$-1\r\n"
 	if size == -1 {
-		return p, nil
+		return NullBulk, nil
+	}
+	if size > r.maxBulkLen {
+		return p, errors.New("wrong payload format. bulk string is larger than proto-max-bulk-len")
+	}
+	b, err := r.readBulk(size + 2)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Payload{}, truncatedError{"wrong payload format. bulk string size is not the same as the size in the payload"}
+		}
+		return Payload{}, errors.New("wrong payload format. bulk string size is not the same as the size in the payload")
 	}
-	b, _, err = r.reader.ReadLine()
-	if err != nil || int64(len(b)) != size {
+	if b[size] != '\r' || b[size+1] != '\n' {
 		return Payload{}, errors.New("wrong payload format. bulk string size is not the same as the size in the payload")
 	}
 
-	p.Bulk = string(b)
+	p.Bulk = b[:size]
 	p.DataType = string(BULKSTRING)
 	return p, nil
 }
 
+// Bulk strings larger than this are read in chunks, so that a declared length
+// not followed by the data doesn't allocate it
+const bulkChunkSize = 64 * 1024
+
+// readBulk reads n bytes, allocating as they arrive above bulkChunkSize
+func (r *RespReader) readBulk(n int64) ([]byte, error) {
+	if n <= bulkChunkSize {
+		b := make([]byte, n)
+		_, err := io.ReadFull(&r.reader, b)
+		return b, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(bulkChunkSize)
+	if _, err := io.CopyN(&buf, &r.reader, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // Expected format +<payload>\r\n
 func (r *RespReader) readString() (Payload, error) {
 	p := Payload{}
-	b, _, err := r.reader.ReadLine()
+	b, err := r.readLine()
 	if err != nil {
-		return p, errors.New("wrong payload format. unable to parse size")
+		return p, errors.New("wrong payload format. unable to parse string")
 	}
 	p.Str = string(b)
 	p.DataType = string(STRING)
@@ -140,9 +281,9 @@ func (r *RespReader) readString() (Payload, error) {
 
 func (r *RespReader) readError() (Payload, error) {
 	p := Payload{}
-	b, _, err := r.reader.ReadLine()
+	b, err := r.readLine()
 	if err != nil {
-		return p, errors.New("wrong payload format. unable to parse size")
+		return p, errors.New("wrong payload format. unable to parse error")
 	}
 	p.Str = string(b)
 	p.DataType = string(ERROR)
@@ -151,16 +292,16 @@ func (r *RespReader) readError() (Payload, error) {
 
 func (r *RespReader) readInteger() (Payload, error) {
 	p := Payload{}
-	b, _, err := r.reader.ReadLine()
+	b, err := r.readLine()
 	if err != nil {
-		return p, errors.New("wrong payload format. unable to parse size")
+		return p, errors.New("wrong payload format. unable to parse integer")
 	}
-	size, _ := strconv.ParseInt(string(b), 10, 64)
-	if size == -1 {
+	num, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
 		return p, errors.New("unable to convert to integer")
 	}
 
-	p.Num = int(size)
+	p.Num = int(num)
 	p.DataType = string(INTEGER)
 	return p, nil
 }
@@ -177,7 +318,6 @@ func (p *Payload) WriteString() []byte {
 func (p *Payload) WriteErrors() []byte {
 	bytes := make([]byte, 0)
 	bytes = append(bytes, ERROR)
-	bytes = append(bytes, []byte("Error ")...)
 	bytes = append(bytes, p.Str...)
 	bytes = append(bytes, '\r', '\n')
 
@@ -193,17 +333,23 @@ func (p *Payload) WriteIntegers() []byte {
 	return bytes
 }
 func (p *Payload) WriteBulkString() []byte {
+	if p.Null {
+		return []byte("$-1\r\n")
+	}
 	bytes := make([]byte, 0)
 	bytes = append(bytes, BULKSTRING)
 	bytes = append(bytes, []byte(strconv.Itoa(len(p.Bulk)))...)
 	bytes = append(bytes, '\r', '\n')
-	bytes = append(bytes, []byte(p.Bulk)...)
+	bytes = append(bytes, p.Bulk...)
 	bytes = append(bytes, '\r', '\n')
 
 	return bytes
 }
 
 func (p *Payload) WriteArray() []byte {
+	if p.Null {
+		return []byte("*-1\r\n")
+	}
 	bytes := make([]byte, 0)
 	bytes = append(bytes, ARRAY)
 	bytes = append(bytes, []byte(strconv.Itoa(len(p.Array)))...)
@@ -227,25 +373,54 @@ func (p *Payload) Write() []byte {
 		bytes = p.WriteBulkString()
 	case string(ARRAY):
 		bytes = p.WriteArray()
+	case string(NULL), string(BOOLEAN), string(DOUBLE), string(BIGNUMBER), string(VERBATIM),
+		string(MAP), string(SET), string(PUSH):
+		bytes = p.writeResp3()
 	default:
 		bytes = []byte("*-1\r\n")
 	}
 
+	if len(p.Attributes) > 0 {
+		bytes = append(p.writeAttributes(), bytes...)
+	}
 	return bytes
 }
 
+// Write writes the payload and flushes it to the connection
 func (w *RespWriter) Write(p *Payload) error {
-	_, err := w.writer.Write(p.Write())
-	if err != nil {
+	if err := w.Append(p); err != nil {
 		return err
 	}
-	err = w.writer.Flush()
+	return w.writer.Flush()
+}
+
+// Append writes the payload to the buffer without flushing it, the buffer is
+// only sent when it is full or on Flush. Used to batch pipelined replies.
+func (w *RespWriter) Append(p *Payload) error {
+	if w.proto < 3 {
+		resp2 := p.ToResp2()
+		p = &resp2
+	} else {
+		resp3 := p.ToResp3()
+		p = &resp3
+	}
+	_, err := w.writer.Write(p.Write())
 	return err
 }
 
+// Flush sends the buffered replies
+func (w *RespWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// Buffered returns the number of bytes written but not flushed yet
+func (w *RespWriter) Buffered() int {
+	return w.writer.Buffered()
+}
+
 func ParseRequest(cmd *Payload) (string, []Payload) {
 	// first bulk string is the command
-	request := strings.ToUpper(cmd.Array[0].Bulk)
+	request := strings.ToUpper(string(cmd.Array[0].Bulk))
 	params := cmd.Array[1:]
 
 	return request, params
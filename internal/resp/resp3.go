@@ -0,0 +1,243 @@
+package resp
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// RESP3 types, negotiated by clients with HELLO 3.
+// Maps and attributes keep their keys and values interleaved in Array, the
+// way they are sent on the wire. Attributes are attached to the reply that
+// follows them.
+
+const (
+	NULL      = '_'
+	BOOLEAN   = '#'
+	DOUBLE    = ','
+	BIGNUMBER = '('
+	VERBATIM  = '='
+	MAP       = '%'
+	SET       = '~'
+	ATTRIBUTE = '|'
+	PUSH      = '>'
+)
+
+// Expected format #t\r\n or #f\r\n
+func (r *RespReader) readBoolean() (Payload, error) {
+	b, err := r.readLine()
+	if err != nil || len(b) != 1 || (b[0] != 't' && b[0] != 'f') {
+		return Payload{}, errors.New("wrong payload format. unable to parse boolean")
+	}
+	return Payload{DataType: string(BOOLEAN), Bool: b[0] == 't'}, nil
+}
+
+// Expected format ,<floating point number>\r\n, inf, -inf and nan included
+func (r *RespReader) readDouble() (Payload, error) {
+	b, err := r.readLine()
+	if err != nil {
+		return Payload{}, errors.New("wrong payload format. unable to parse double")
+	}
+	d, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return Payload{}, errors.New("wrong payload format. unable to parse double")
+	}
+	return Payload{DataType: string(DOUBLE), Double: d}, nil
+}
+
+// Expected format (<big number>\r\n, the number is kept as a string
+func (r *RespReader) readBigNumber() (Payload, error) {
+	b, err := r.readLine()
+	if err != nil || !isBigNumber(b) {
+		return Payload{}, errors.New("wrong payload format. unable to parse big number")
+	}
+	return Payload{DataType: string(BIGNUMBER), Str: string(b)}, nil
+}
+
+func isBigNumber(b []byte) bool {
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Expected format =<size>\r\n<format>:<payload>\r\n, the 3 bytes format is
+// stored in Str
+func (r *RespReader) readVerbatim() (Payload, error) {
+	p, err := r.readBulkString()
+	if err != nil {
+		return p, err
+	}
+	if len(p.Bulk) < 4 || p.Bulk[3] != ':' {
+		return Payload{}, errors.New("wrong payload format. verbatim string without format")
+	}
+	return Payload{DataType: string(VERBATIM), Str: string(p.Bulk[:3]), Bulk: p.Bulk[4:]}, nil
+}
+
+// readAggregate reads the elements of an array, a set, a push, or of a map
+// when pairs is set
+func (r *RespReader) readAggregate(dataType byte, pairs bool) (Payload, error) {
+	size, err := r.readSize()
+	if err != nil {
+		return Payload{}, err
+	}
+	if size == -1 {
+		return Payload{}, errors.New("wrong payload format. invalid size")
+	}
+	if pairs {
+		size *= 2
+	}
+	p := Payload{DataType: string(dataType), Array: make([]Payload, 0)}
+	for i := 0; i < int(size); i++ {
+		payload, err := r.Read()
+		if err != nil {
+			return p, err
+		}
+		p.Array = append(p.Array, payload)
+	}
+	return p, nil
+}
+
+// Expected format |<count>\r\n<key><value>...<reply>, the attribute is
+// returned in Attributes of the reply
+func (r *RespReader) readAttribute() (Payload, error) {
+	attribute, err := r.readAggregate(ATTRIBUTE, true)
+	if err != nil {
+		return attribute, err
+	}
+	p, err := r.Read()
+	if err != nil {
+		return p, err
+	}
+	p.Attributes = attribute.Array
+	return p, nil
+}
+
+// FormatDouble formats a double the way Redis does, with inf, -inf and nan
+// for the special values, and integral values that are exact as integers
+// without an exponent: 1700000000 rather than 1.7e+09
+func FormatDouble(d float64) string {
+	switch {
+	case math.IsInf(d, 1):
+		return "inf"
+	case math.IsInf(d, -1):
+		return "-inf"
+	case math.IsNaN(d):
+		return "nan"
+	case d != 0 && d == math.Trunc(d) && math.Abs(d) <= 1<<53:
+		return strconv.FormatInt(int64(d), 10)
+	}
+	return strconv.FormatFloat(d, 'g', -1, 64)
+}
+
+func (p *Payload) writeAttributes() []byte {
+	bytes := appendHeader(make([]byte, 0), ATTRIBUTE, len(p.Attributes)/2)
+	for i := range p.Attributes {
+		bytes = append(bytes, p.Attributes[i].Write()...)
+	}
+	return bytes
+}
+
+func (p *Payload) writeResp3() []byte {
+	bytes := make([]byte, 0)
+	switch p.DataType {
+	case string(NULL):
+		bytes = append(bytes, NULL, '\r', '\n')
+	case string(BOOLEAN):
+		value := byte('f')
+		if p.Bool {
+			value = 't'
+		}
+		bytes = append(bytes, BOOLEAN, value, '\r', '\n')
+	case string(DOUBLE):
+		bytes = append(bytes, DOUBLE)
+		bytes = append(bytes, FormatDouble(p.Double)...)
+		bytes = append(bytes, '\r', '\n')
+	case string(BIGNUMBER):
+		bytes = append(bytes, BIGNUMBER)
+		bytes = append(bytes, p.Str...)
+		bytes = append(bytes, '\r', '\n')
+	case string(VERBATIM):
+		bytes = appendHeader(bytes, VERBATIM, len(p.Bulk)+4)
+		bytes = append(bytes, p.Str...)
+		bytes = append(bytes, ':')
+		bytes = append(bytes, p.Bulk...)
+		bytes = append(bytes, '\r', '\n')
+	case string(MAP), string(SET), string(PUSH):
+		size := len(p.Array)
+		if p.DataType == string(MAP) {
+			size /= 2
+		}
+		bytes = appendHeader(bytes, p.DataType[0], size)
+		for i := range p.Array {
+			bytes = append(bytes, p.Array[i].Write()...)
+		}
+	}
+	return bytes
+}
+
+func appendHeader(bytes []byte, dataType byte, size int) []byte {
+	bytes = append(bytes, dataType)
+	bytes = append(bytes, strconv.Itoa(size)...)
+	return append(bytes, '\r', '\n')
+}
+
+// ToResp2 converts the RESP3 types of the payload to their RESP2 equivalent:
+// maps, sets and pushes become arrays, doubles, big numbers and verbatim
+// strings become bulk strings, booleans become integers and attributes are
+// dropped
+func (p *Payload) ToResp2() Payload {
+	switch p.DataType {
+	case string(NULL):
+		return NullBulk
+	case string(BOOLEAN):
+		var num int
+		if p.Bool {
+			num = 1
+		}
+		return Payload{DataType: string(INTEGER), Num: num}
+	case string(DOUBLE):
+		return Payload{DataType: string(BULKSTRING), Bulk: []byte(FormatDouble(p.Double))}
+	case string(BIGNUMBER):
+		return Payload{DataType: string(BULKSTRING), Bulk: []byte(p.Str)}
+	case string(VERBATIM):
+		return Payload{DataType: string(BULKSTRING), Bulk: p.Bulk}
+	case string(ARRAY), string(MAP), string(SET), string(PUSH):
+		if p.Null {
+			return NullArray
+		}
+		array := make([]Payload, len(p.Array))
+		for i := range p.Array {
+			array[i] = p.Array[i].ToResp2()
+		}
+		return Payload{DataType: string(ARRAY), Array: array}
+	}
+	downgraded := *p
+	downgraded.Attributes = nil
+	return downgraded
+}
+
+// ToResp3 converts the null bulk strings and null arrays of the payload to
+// the RESP3 null
+func (p *Payload) ToResp3() Payload {
+	if p.Null {
+		return Payload{DataType: string(NULL), Attributes: p.Attributes}
+	}
+	if p.Array == nil {
+		return *p
+	}
+	converted := *p
+	converted.Array = make([]Payload, len(p.Array))
+	for i := range p.Array {
+		converted.Array[i] = p.Array[i].ToResp3()
+	}
+	return converted
+}
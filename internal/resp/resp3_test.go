@@ -0,0 +1,94 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResp3RoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		wire    string
+	}{
+		{"null", Payload{DataType: string(NULL)}, "_\r\n"},
+		{"true", Payload{DataType: string(BOOLEAN), Bool: true}, "#t\r\n"},
+		{"false", Payload{DataType: string(BOOLEAN)}, "#f\r\n"},
+		{"double", Payload{DataType: string(DOUBLE), Double: 1.5}, ",1.5\r\n"},
+		{"inf", Payload{DataType: string(DOUBLE), Double: math.Inf(-1)}, ",-inf\r\n"},
+		{"big number", Payload{DataType: string(BIGNUMBER), Str: "-3492890328409238509324850943850943825024385"}, "(-3492890328409238509324850943850943825024385\r\n"},
+		{"verbatim", Payload{DataType: string(VERBATIM), Str: "txt", Bulk: []byte("Some string")}, "=15\r\ntxt:Some string\r\n"},
+		{"map", Payload{DataType: string(MAP), Array: []Payload{
+			{DataType: string(BULKSTRING), Bulk: []byte("first")},
+			{DataType: string(INTEGER), Num: 1},
+		}}, "%1\r\n$5\r\nfirst\r\n:1\r\n"},
+		{"set", Payload{DataType: string(SET), Array: []Payload{
+			{DataType: string(BULKSTRING), Bulk: []byte("a")},
+			{DataType: string(BOOLEAN), Bool: true},
+		}}, "~2\r\n$1\r\na\r\n#t\r\n"},
+		{"push", Payload{DataType: string(PUSH), Array: []Payload{
+			{DataType: string(BULKSTRING), Bulk: []byte("message")},
+		}}, ">1\r\n$7\r\nmessage\r\n"},
+		{"attribute", Payload{DataType: string(INTEGER), Num: 2, Attributes: []Payload{
+			{DataType: string(BULKSTRING), Bulk: []byte("ttl")},
+			{DataType: string(INTEGER), Num: 100},
+		}}, "|1\r\n$3\r\nttl\r\n:100\r\n:2\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wire, string(tt.payload.Write()))
+
+			res, err := NewRespReader(strings.NewReader(tt.wire)).Read()
+			require.NoError(t, err)
+			require.Equal(t, tt.wire, string(res.Write()))
+		})
+	}
+}
+
+func TestFormatDouble(t *testing.T) {
+	for d, expected := range map[float64]string{
+		1700000000:           "1700000000",
+		-3:                   "-3",
+		1 << 53:              "9007199254740992",
+		1 << 54:              "1.8014398509481984e+16",
+		1.5:                  "1.5",
+		2e30:                 "2e+30",
+		0.1:                  "0.1",
+		0:                    "0",
+		math.Copysign(0, -1): "-0",
+	} {
+		if got := FormatDouble(d); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	}
+}
+
+func TestResp3InvalidPayload(t *testing.T) {
+	for _, wire := range []string{"#x\r\n", ",abc\r\n", "(12a\r\n", "=3\r\ntxt\r\n", "%-1\r\n", "%1\r\n:1\r\n"} {
+		_, err := NewRespReader(strings.NewReader(wire)).Read()
+		require.Error(t, err, wire)
+	}
+}
+
+func TestRespWriterProtocol(t *testing.T) {
+	payload := Payload{DataType: string(MAP), Array: []Payload{
+		{DataType: string(BULKSTRING), Bulk: []byte("score")},
+		{DataType: string(DOUBLE), Double: 2.5},
+		{DataType: string(BULKSTRING), Bulk: []byte("flag")},
+		{DataType: string(BOOLEAN), Bool: true},
+	}}
+
+	var buf bytes.Buffer
+	writer := NewRespWriter(&buf)
+	require.NoError(t, writer.Write(&payload))
+	require.Equal(t, "*4\r\n$5\r\nscore\r\n$3\r\n2.5\r\n$4\r\nflag\r\n:1\r\n", buf.String())
+
+	buf.Reset()
+	writer.SetProtocol(3)
+	require.NoError(t, writer.Write(&payload))
+	require.Equal(t, "%2\r\n$5\r\nscore\r\n,2.5\r\n$4\r\nflag\r\n#t\r\n", buf.String())
+}
@@ -2,6 +2,8 @@ package resp
 
 import (
 	"bytes"
+	"io"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -17,7 +19,7 @@ func TestRespReader(t *testing.T) {
 
 		res, err := respReader.Read()
 		require.NoError(t, err)
-		require.Equal(t, "hello", res.Bulk)
+		require.Equal(t, "hello", string(res.Bulk))
 	})
 
 	t.Run("Null Bulk String", func(t *testing.T) {
@@ -26,7 +28,7 @@ func TestRespReader(t *testing.T) {
 
 		res, err := respReader.Read()
 		require.NoError(t, err)
-		require.Equal(t, "", res.Bulk)
+		require.Equal(t, "", string(res.Bulk))
 	})
 	t.Run("Null value as bulk String", func(t *testing.T) {
 		bulkString := "$-1\r\n"
@@ -34,7 +36,14 @@ func TestRespReader(t *testing.T) {
 
 		res, err := respReader.Read()
 		require.NoError(t, err)
-		require.Equal(t, "", res.Bulk)
+		require.Equal(t, NullBulk, res)
+	})
+	t.Run("Null array", func(t *testing.T) {
+		respReader := NewRespReader(strings.NewReader("*-1\r\n"))
+
+		res, err := respReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, NullArray, res)
 	})
 
 	t.Run("Array of bulk string", func(t *testing.T) {
@@ -45,8 +54,53 @@ func TestRespReader(t *testing.T) {
 		require.NoError(t, err)
 
 		require.Equal(t, len(res.Array), 2)
-		require.Equal(t, "echo", res.Array[0].Bulk)
-		require.Equal(t, "hello-world", res.Array[1].Bulk)
+		require.Equal(t, "echo", string(res.Array[0].Bulk))
+		require.Equal(t, "hello-world", string(res.Array[1].Bulk))
+	})
+
+	t.Run("Binary bulk string", func(t *testing.T) {
+		bulkString := "*2\r\n$8\r\na\r\nb\nc\x00d\r\n$3\r\n\r\n\r\r\n"
+		respReader := NewRespReader(strings.NewReader(bulkString))
+
+		res, err := respReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("a\r\nb\nc\x00d"), res.Array[0].Bulk)
+		require.Equal(t, []byte("\r\n\r"), res.Array[1].Bulk)
+	})
+
+	t.Run("Bulk string larger than the buffer", func(t *testing.T) {
+		value := strings.Repeat("x\r\n", 10000)
+		bulkString := "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		respReader := NewRespReader(strings.NewReader(bulkString))
+
+		res, err := respReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, value, string(res.Bulk))
+	})
+
+	t.Run("Bulk string above max bulk len", func(t *testing.T) {
+		respReader := NewRespReader(strings.NewReader("$11\r\nhello-world\r\n"))
+		respReader.SetMaxBulkLen(10)
+
+		_, err := respReader.Read()
+		require.Error(t, err)
+	})
+
+	t.Run("Truncated bulk string", func(t *testing.T) {
+		for _, bulkString := range []string{"$5\r\nhel", "$5\r\nhelloXX", "$-5\r\n", "$abc\r\n", "$5\nhello\r\n"} {
+			respReader := NewRespReader(strings.NewReader(bulkString))
+
+			_, err := respReader.Read()
+			require.Error(t, err, bulkString)
+		}
+	})
+
+	t.Run("Negative integer", func(t *testing.T) {
+		respReader := NewRespReader(strings.NewReader(":-1\r\n"))
+
+		res, err := respReader.Read()
+		require.NoError(t, err)
+		require.Equal(t, -1, res.Num)
 	})
 
 	t.Run("Invalid Bulk String", func(t *testing.T) {
@@ -86,7 +140,7 @@ func TestRespWriter(t *testing.T) {
 			t.Fatalf("Write returned an error: %v", err)
 		}
 
-		expected := "-Error WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		expected := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
 		require.Equal(t, expected, buf.String())
 	})
 
@@ -104,7 +158,7 @@ func TestRespWriter(t *testing.T) {
 
 	t.Run("Write bulk string", func(t *testing.T) {
 		writer, buf := createTestRespWriter()
-		payload := Payload{DataType: string(BULKSTRING), Bulk: "bulk data"}
+		payload := Payload{DataType: string(BULKSTRING), Bulk: []byte("bulk data")}
 
 		if err := writer.Write(&payload); err != nil {
 			t.Fatalf("Write returned an error: %v", err)
@@ -137,8 +191,8 @@ func TestRespWriter(t *testing.T) {
 		payload := Payload{
 			DataType: string(ARRAY),
 			Array: []Payload{
-				{DataType: string(BULKSTRING), Bulk: "first"},
-				{DataType: string(BULKSTRING), Bulk: "second"},
+				{DataType: string(BULKSTRING), Bulk: []byte("first")},
+				{DataType: string(BULKSTRING), Bulk: []byte("second")},
 			},
 		}
 
@@ -153,3 +207,109 @@ func TestRespWriter(t *testing.T) {
 		require.Equal(t, expected, buf.String())
 	})
 }
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte("hello"), []byte("world"))
+	f.Add([]byte(""), []byte("\r\n"))
+	f.Add([]byte("a\r\nb"), []byte{0, 0xff, '\n', '\r'})
+
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		payload := Payload{DataType: string(ARRAY), Array: []Payload{
+			{DataType: string(BULKSTRING), Bulk: a},
+			{DataType: string(BULKSTRING), Bulk: b},
+		}}
+
+		res, err := NewRespReader(bytes.NewReader(payload.Write())).Read()
+		require.NoError(t, err)
+		require.Len(t, res.Array, 2)
+		require.Equal(t, a, append([]byte{}, res.Array[0].Bulk...))
+		require.Equal(t, b, append([]byte{}, res.Array[1].Bulk...))
+	})
+}
+
+// Arbitrary input must be rejected with an error, never panic
+func FuzzRead(f *testing.F) {
+	f.Add([]byte("*2\r\n$4\r\necho\r\n$11\r\nhello-world\r\n"))
+	f.Add([]byte("$-1\r\n"))
+	f.Add([]byte(":12\r\n+OK\r\n-ERR\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		respReader := NewRespReader(bytes.NewReader(data))
+		respReader.SetMaxBulkLen(1024 * 1024)
+		for {
+			if _, err := respReader.Read(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// Replies as sent by Redis, byte for byte, with both protocols
+func TestGoldenBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		resp2   string
+		resp3   string
+	}{
+		{"null bulk", NullBulk, "$-1\r\n", "_\r\n"},
+		{"null array", NullArray, "*-1\r\n", "_\r\n"},
+		{"empty bulk", Payload{DataType: string(BULKSTRING), Bulk: []byte{}}, "$0\r\n\r\n", "$0\r\n\r\n"},
+		{"empty array", Payload{DataType: string(ARRAY), Array: []Payload{}}, "*0\r\n", "*0\r\n"},
+		{"array with null", Payload{DataType: string(ARRAY), Array: []Payload{
+			{DataType: string(BULKSTRING), Bulk: []byte("a")}, NullBulk,
+		}}, "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{"generic error", NewError("ERR", "unknown command 'foo', with args beginning with: "),
+			"-ERR unknown command 'foo', with args beginning with: \r\n",
+			"-ERR unknown command 'foo', with args beginning with: \r\n"},
+		{"typed error", NewError("WRONGTYPE", "Operation against a key holding the wrong kind of value"),
+			"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+			"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for proto, expected := range map[int]string{2: tt.resp2, 3: tt.resp3} {
+				writer, buf := createTestRespWriter()
+				writer.SetProtocol(proto)
+				require.NoError(t, writer.Write(&tt.payload))
+				require.Equal(t, expected, buf.String(), "RESP%d", proto)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	p := NewError("NOSCRIPT", "No matching script")
+	require.Equal(t, "NOSCRIPT", p.ErrorCode())
+
+	res, err := NewRespReader(strings.NewReader("-WRONGTYPE Operation against a key\r\n")).Read()
+	require.NoError(t, err)
+	require.Equal(t, "WRONGTYPE", res.ErrorCode())
+}
+
+func TestTruncated(t *testing.T) {
+	command := "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
+	for i := 1; i < len(command); i++ {
+		_, err := NewRespReader(strings.NewReader(command[:i])).Read()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, command[:i])
+	}
+	_, err := NewRespReader(strings.NewReader("")).Read()
+	require.Equal(t, io.EOF, err)
+	_, err = NewRespReader(strings.NewReader("*2\r\n$3\r\nGETX\r\n")).Read()
+	require.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestLargeBulk(t *testing.T) {
+	value := strings.Repeat("x", 3*bulkChunkSize+1)
+	res, err := NewRespReader(strings.NewReader("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")).Read()
+	require.NoError(t, err)
+	require.Equal(t, value, string(res.Bulk))
+
+	// The declared length is not allocated before the data arrives
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = NewRespReader(strings.NewReader("$500000000\r\nshort\r\n")).Read()
+	runtime.ReadMemStats(&after)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1024*1024))
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 
+	"github.com/ger/redis-lite-go/internal/config"
 	"github.com/ger/redis-lite-go/internal/handler"
 )
 
@@ -15,9 +18,63 @@ var (
 	version   string
 )
 
+// Offline tools, run as the first argument or as the name of the binary the
+// way redis-check-aof is
+var subcommands = map[string]func(args []string) error{
+	"redis-lite-check-aof":   checkAof,
+	"redis-lite-convert-rdb": convertRdb,
+}
+
+// checkAof validates an AOF file or the files of a manifest, and truncates
+// invalid files with --fix. Encrypted files are read with the keys of
+// --key-file.
+func checkAof(args []string) error {
+	flags := flag.NewFlagSet("redis-lite-check-aof", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "Truncate invalid files after the last valid command")
+	keyFile := flags.String("key-file", "", "Key file of encrypted files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 1 {
+		return errors.New("Usage: redis-lite-check-aof [--fix] [--key-file <file>] <file.manifest|file.aof>")
+	}
+	if err := handler.LoadEncryptionKeys(*keyFile); err != nil {
+		return err
+	}
+	report, err := handler.CheckAof(args[0], *fix)
+	fmt.Print(report)
+	return err
+}
+
+// convertRdb converts a Redis RDB file to a snapshot or the other way round
+func convertRdb(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: redis-lite-convert-rdb <input> <output>")
+	}
+	result, err := handler.ConvertDump(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
 func main() {
+	name, args := filepath.Base(os.Args[0]), os.Args[1:]
+	if _, ok := subcommands[name]; !ok && len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if subcommand, ok := subcommands[name]; ok {
+		if err := subcommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	displayVersion := flag.Bool("version", false, "Display version and exit")
+	configFile := flag.String("config", "", "Path to the configuration file")
 	flag.Parse()
 
 	if *displayVersion {
@@ -25,6 +82,17 @@ func main() {
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
+
+	cfg := config.New()
+	if *configFile != "" {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	handler.Init(cfg)
+
 	l, err := net.Listen("tcp", ":6379")
 	if err != nil {
 		log.Fatal(err)
@@ -34,7 +102,7 @@ func main() {
 
 	aof, err := handler.NewAof()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 
 	for {